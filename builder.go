@@ -0,0 +1,51 @@
+package forwarded
+
+import "strings"
+
+// Builder builds a Forwarded header value from elements.
+// The zero value is an empty Builder ready to use.
+type Builder struct {
+	b strings.Builder
+}
+
+// NewBuilder returns a new empty Builder.
+func NewBuilder() *Builder {
+	return new(Builder)
+}
+
+// Append appends element e to builder b. Nil and empty elements
+// are skipped. It returns b, so calls can be chained.
+func (b *Builder) Append(e *Element) *Builder {
+	if e == nil {
+		return b
+	}
+	s := e.String()
+	if s == "" {
+		return b
+	}
+	if b.b.Len() > 0 {
+		b.b.WriteString(", ")
+	}
+	b.b.WriteString(s)
+	return b
+}
+
+// String returns the elements appended to builder b, separated
+// by ", ".
+func (b *Builder) String() string {
+	return b.b.String()
+}
+
+// AppendTo returns the existing header value followed by the
+// elements appended to builder b. If the existing value is empty,
+// the elements are returned without a leading comma. If builder b
+// is empty, the existing value is returned.
+func (b *Builder) AppendTo(existing string) string {
+	switch {
+	case b.b.Len() == 0:
+		return existing
+	case trimOWS(existing) == "":
+		return b.String()
+	}
+	return existing + ", " + b.String()
+}
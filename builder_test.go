@@ -0,0 +1,34 @@
+package forwarded
+
+import "testing"
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder().
+		Append(&Element{For: "192.0.2.43", Proto: "https"}).
+		Append(&Element{}).
+		Append(nil).
+		Append(&Element{For: "[2001:db8:cafe::17]:4711", By: "_proxy"})
+
+	want := `for=192.0.2.43;proto=https, by=_proxy;for="[2001:db8:cafe::17]:4711"`
+	if got := b.String(); got != want {
+		t.Errorf("String() = %s, want: %s", got, want)
+	}
+
+	cases := []struct {
+		b        *Builder
+		existing string
+		want     string
+	}{
+		{b, "", want},
+		{b, " ", want},
+		{b, "for=198.51.100.17", "for=198.51.100.17, " + want},
+		{NewBuilder(), "for=198.51.100.17", "for=198.51.100.17"},
+		{NewBuilder(), "", ""},
+		{new(Builder).Append(&Element{For: "unknown"}), "for=_a", "for=_a, for=unknown"},
+	}
+	for _, c := range cases {
+		if got := c.b.AppendTo(c.existing); got != c.want {
+			t.Errorf("AppendTo(%q) = %s, want: %s", c.existing, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package forwarded
+
+import (
+	"iter"
+	"strings"
+	"unsafe"
+)
+
+// ParseBytes is like [Parse], but parses a line given as a byte
+// slice without converting it to a string first. Only the values
+// of the yielded elements are copied, so they remain valid when
+// line is modified afterwards. The line must not be modified while
+// iterating.
+// The error returned is of type [*ParseError].
+func ParseBytes(line []byte, reverse bool) iter.Seq2[*Element, error] {
+	s := unsafe.String(unsafe.SliceData(line), len(line))
+	return func(yield func(*Element, error) bool) {
+		for elem, err := range parse(s, reverse, &bytesOptions) {
+			if pe, ok := err.(*ParseError); ok {
+				err = &ParseError{Msg: pe.Msg, Text: strings.Clone(pe.Text), Offset: pe.Offset, err: pe.err}
+			}
+			if !yield(elem, err) {
+				return
+			}
+		}
+	}
+}
+
+var bytesOptions = options{
+	clone: true,
+}
@@ -0,0 +1,81 @@
+package forwarded
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	for _, c := range parseTests {
+		for _, reverse := range []bool{false, true} {
+			line := []byte(c.in)
+			var got []*Element
+			for elem, err := range ParseBytes(line, reverse) {
+				if err != nil {
+					t.Fatalf("%s: got error: %v", c.name, err)
+				}
+				got = append(got, elem)
+			}
+			clear(line)
+			if reverse {
+				slices.Reverse(got)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s (reverse=%v):\ngot:  %v\nwant: %v", c.name, reverse, got, c.want)
+			}
+		}
+	}
+
+	for _, c := range parseErrorTests {
+		line := []byte(c.in)
+		var err error
+		for _, err = range ParseBytes(line, false) {
+			if err != nil {
+				break
+			}
+		}
+		pe, ok := err.(*ParseError)
+		if !ok || pe.Msg != c.msg {
+			t.Fatalf("ParseBytes(%s) error = %v, want %q", c.in, err, c.msg)
+		}
+		text := strings.Clone(pe.Text)
+		clear(line)
+		if pe.Text != text {
+			t.Errorf("ParseBytes(%s) error text refers to line", c.in)
+		}
+	}
+}
+
+func BenchmarkParseBytes(b *testing.B) {
+	var sb strings.Builder
+	for i := range 32 {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(`for="[2001:db8:cafe::17]:4711";by=203.0.113.60;proto=https;host=example.com`)
+	}
+	line := []byte(sb.String())
+
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := Last(string(line)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, err := range ParseBytes(line, true) {
+				if err != nil {
+					b.Fatal(err)
+				}
+				break
+			}
+		}
+	})
+}
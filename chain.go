@@ -0,0 +1,396 @@
+package forwarded
+
+import (
+	"iter"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Chain is a list of elements in header order, from the element
+// added by the proxy nearest to the client to the element added
+// by the proxy nearest to the server.
+type Chain []*Element
+
+// String returns the header value of chain c.
+// It assumes that all elements are valid.
+func (c Chain) String() string {
+	elems := make([]string, len(c))
+	for i, e := range c {
+		elems[i] = e.String()
+	}
+	return strings.Join(elems, ", ")
+}
+
+// Size returns the length of the string returned by
+// [Chain.String] without building it.
+func (c Chain) Size() int {
+	n := 0
+	for i, e := range c {
+		if i > 0 {
+			n += len(", ")
+		}
+		n += e.Size()
+	}
+	return n
+}
+
+// ObfuscateAddrs returns a copy of chain c in which every by and
+// for node that is an IP address is replaced by the identifier
+// obf returns for that address. Ports of those nodes are dropped,
+// so a client maps to the same identifier for every connection.
+// Other nodes, proto, host and extra parameters are kept.
+func (c Chain) ObfuscateAddrs(obf *Obfuscator) Chain {
+	obfuscate := func(n Node) Node {
+		addr, _, _ := n.AddrPort()
+		if !addr.IsValid() {
+			return n
+		}
+		return obf.Node(NodeFromAddr(addr))
+	}
+
+	out := make(Chain, len(c))
+	for i, e := range c {
+		out[i] = &Element{
+			By:    obfuscate(e.By),
+			For:   obfuscate(e.For),
+			Proto: e.Proto,
+			Host:  e.Host,
+			Extra: slices.Clone(e.Extra),
+		}
+	}
+	return out
+}
+
+// ProtoConflict reports whether the protos in chain c are
+// inconsistent. Walking from the client towards the server, a
+// proxy may terminate TLS, so proto may change from https to
+// another proto, but once a request was received without https
+// no later proxy can have received it over https. Elements
+// without proto are ignored.
+func (c Chain) ProtoConflict() bool {
+	plain := false
+	for _, e := range c {
+		switch {
+		case e.Proto == "":
+		case e.ProtoIsSecure():
+			if plain {
+				return true
+			}
+		default:
+			plain = true
+		}
+	}
+	return false
+}
+
+// ExtraValues returns the value of extra parameter key from
+// each element of chain c that has it, in chain order. Keys
+// are compared case-insensitively. If an element has the
+// parameter more than once, only the first value is used.
+func (c Chain) ExtraValues(key string) []string {
+	var values []string
+	for _, e := range c {
+		for _, p := range e.Extra {
+			if strings.EqualFold(p.Key, key) {
+				values = append(values, p.Value)
+				break
+			}
+		}
+	}
+	return values
+}
+
+// Dedup removes consecutive elements that are equal according
+// to [Element.Equal], keeping the first of them. Like
+// [slices.Compact] it modifies elems in place and returns the
+// shortened slice, so no slice is allocated.
+func Dedup(elems []*Element) []*Element {
+	return slices.CompactFunc(elems, (*Element).Equal)
+}
+
+// Canonicalize returns a copy of chain c in canonical form, so
+// that semantically equal chains have equal string forms. In the
+// canonical form:
+//   - proto and host are lowercase;
+//   - by and for nodes that are IP addresses use the canonical
+//     address format and numeric ports have no leading zeros;
+//   - extra parameter keys are lowercase and sorted by key,
+//     keeping the order of parameters with the same key.
+func (c Chain) Canonicalize() Chain {
+	out := make(Chain, len(c))
+	for i, e := range c {
+		ce := &Element{
+			By:    canonicalNode(e.By),
+			For:   canonicalNode(e.For),
+			Proto: strings.ToLower(e.Proto),
+			Host:  strings.ToLower(e.Host),
+		}
+		if len(e.Extra) > 0 {
+			ce.Extra = make([]Parameter, len(e.Extra))
+			for j, p := range e.Extra {
+				ce.Extra[j] = Parameter{strings.ToLower(p.Key), p.Value}
+			}
+			slices.SortStableFunc(ce.Extra, func(a, b Parameter) int {
+				return strings.Compare(a.Key, b.Key)
+			})
+		}
+		out[i] = ce
+	}
+	return out
+}
+
+// Canonicalize parses the given line and returns it in the
+// canonical form of [Chain.Canonicalize], written like
+// [Chain.String]. Values are only quoted when required and
+// elements are separated by ", ", so lines that differ only
+// in formatting are canonicalized to the same string. If the
+// line is empty, the empty string is returned.
+// The error returned is of type [*ParseError].
+func Canonicalize(line string) (string, error) {
+	if trimOWS(line) == "" {
+		return "", nil
+	}
+	elems, err := ParseAll(line, false)
+	if err != nil {
+		return "", err
+	}
+	return Chain(elems).Canonicalize().String(), nil
+}
+
+// canonicalNode returns node n in canonical form if it is
+// an IP address, otherwise n is returned unchanged.
+func canonicalNode(n Node) Node {
+	addr, port, _ := n.AddrPort()
+	if !addr.IsValid() {
+		return n
+	}
+	node := NodeFromAddr(addr)
+	if u, ok := port.Uint16(); ok {
+		port = NodePort(strconv.FormatUint(uint64(u), 10))
+	}
+	if port.IsValid() {
+		node += Node(":" + port)
+	}
+	return node
+}
+
+// MatchesPath reports whether the by nodes of the last elements
+// of chain c are, in order, the addresses of the expected proxy
+// path. Only the last len(expected) elements are compared, as
+// those are appended by the proxies; elements before them could
+// have been sent by the client. Every compared element must have
+// a by node that is an IP address, so an obfuscated, unknown or
+// missing by node never matches. Ports are ignored.
+func (c Chain) MatchesPath(expected []netip.Addr) bool {
+	if len(c) < len(expected) {
+		return false
+	}
+	for i, e := range c[len(c)-len(expected):] {
+		addr, _, _ := e.By.AddrPort()
+		if !addr.IsValid() || addr.Unmap() != expected[i].Unmap() {
+			return false
+		}
+	}
+	return true
+}
+
+// Hosts returns the lowercase host of each element of chain c
+// that has one, in chain order. Ports are kept. A host that
+// changes between hops can indicate Host header manipulation.
+func (c Chain) Hosts() []string {
+	var hosts []string
+	for _, e := range c {
+		if e.Host != "" {
+			hosts = append(hosts, strings.ToLower(e.Host))
+		}
+	}
+	return hosts
+}
+
+// ExceedsHops reports whether chain c has more than limit elements.
+// An implausibly long chain can indicate a proxy loop or a forged
+// header, and this is cheaper to check than loop detection.
+func (c Chain) ExceedsHops(limit int) bool {
+	return len(c) > limit
+}
+
+// AnyObfuscatedBy reports whether the by node of any element in
+// chain c is obfuscated, which confirms that proxies hide the
+// internal topology. A by node "unknown" is not obfuscated.
+func (c Chain) AnyObfuscatedBy() bool {
+	for _, e := range c {
+		if e.By.IsObfuscated() {
+			return true
+		}
+	}
+	return false
+}
+
+// truncatedKey is the extra parameter [Chain.TailN] uses to mark
+// a truncated chain. This is a convention of this package and
+// not part of RFC 7239.
+const truncatedKey = "_truncated"
+
+// TailN returns the last n elements of chain c, or c if it has
+// at most n elements. If mark is true and elements are dropped,
+// the first returned element is a copy with the extra parameter
+// "_truncated" set to the number of dropped elements, including
+// those dropped by earlier truncations. If elements are dropped,
+// the returned chain does not share its underlying array with c.
+func (c Chain) TailN(n int, mark bool) Chain {
+	if n >= len(c) {
+		return c
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	dropped := len(c) - n
+	if prev, ok := c.Truncated(); ok {
+		dropped += prev
+	}
+	out := slices.Clone(c[len(c)-n:])
+	if mark {
+		e := *out[0]
+		e.Extra = slices.DeleteFunc(slices.Clone(e.Extra), func(p Parameter) bool {
+			return strings.EqualFold(p.Key, truncatedKey)
+		})
+		e.Extra = append(e.Extra, Parameter{truncatedKey, strconv.Itoa(dropped)})
+		out[0] = &e
+	}
+	return out
+}
+
+// Truncated returns the number of elements dropped from chain c
+// as marked by [Chain.TailN]. It returns false if the first
+// element has no valid truncation mark.
+func (c Chain) Truncated() (int, bool) {
+	if len(c) == 0 {
+		return 0, false
+	}
+	for _, p := range c[0].Extra {
+		if strings.EqualFold(p.Key, truncatedKey) {
+			n, err := strconv.Atoi(p.Value)
+			return n, err == nil && n > 0
+		}
+	}
+	return 0, false
+}
+
+// FirstUntrusted returns the first element, walking from the
+// server towards the client, of which the for node is not an
+// address in one of the trusted prefixes. This is the element
+// describing the client as seen by the outermost trusted proxy.
+func (c Chain) FirstUntrusted(trusted []netip.Prefix) (*Element, bool) {
+	for i := len(c) - 1; i >= 0; i-- {
+		if !isTrusted(c[i].For, trusted) {
+			return c[i], true
+		}
+	}
+	return nil, false
+}
+
+// TrustedSuffix returns the longest run of elements at the end
+// of chain c of which the for node is an address in one of the
+// trusted prefixes. These elements originate from within the
+// trusted infrastructure. The returned chain shares its
+// underlying array with c.
+func (c Chain) TrustedSuffix(trusted []netip.Prefix) Chain {
+	i := len(c)
+	for i > 0 && isTrusted(c[i-1].For, trusted) {
+		i--
+	}
+	return c[i:]
+}
+
+// TrimSpoofed returns the part of chain c that was added by
+// trusted proxies: the element returned by [Chain.FirstUntrusted],
+// which the edge proxy added for the client, and all elements after
+// it. Elements before it could have been forged by the client and
+// are dropped. If all elements are trusted, c is returned. The
+// returned chain shares its underlying array with c.
+func (c Chain) TrimSpoofed(trusted []netip.Prefix) Chain {
+	for i := len(c) - 1; i >= 0; i-- {
+		if !isTrusted(c[i].For, trusted) {
+			return c[i:]
+		}
+	}
+	return c
+}
+
+// EdgeHop returns the element describing the edge proxy: the
+// outermost trusted proxy, which received the request from outside
+// the trusted prefixes. This is the first element of
+// [Chain.TrustedSuffix], of which the for node is the address of the
+// edge proxy. It returns false if there is no trusted suffix or if
+// the whole chain is trusted, so the request never entered from
+// outside.
+func (c Chain) EdgeHop(trusted []netip.Prefix) (*Element, bool) {
+	suffix := c.TrustedSuffix(trusted)
+	if len(suffix) == 0 || len(suffix) == len(c) {
+		return nil, false
+	}
+	return suffix[0], true
+}
+
+// ClientInAny resolves the client address of chain c as the for
+// node of [Chain.FirstUntrusted] and returns the first candidate
+// prefix that contains it. It returns false if the client address
+// cannot be resolved or is not in any of the candidate prefixes.
+func (c Chain) ClientInAny(trusted []netip.Prefix, candidates []netip.Prefix) (netip.Prefix, bool) {
+	addr, ok := c.clientAddr(trusted)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	for _, p := range candidates {
+		if p.Contains(addr) {
+			return p, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// clientAddr returns the address of the for node of the first
+// untrusted element in chain c, if it is an IP address.
+func (c Chain) clientAddr(trusted []netip.Prefix) (netip.Addr, bool) {
+	e, ok := c.FirstUntrusted(trusted)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	addr, _, _ := e.For.AddrPort()
+	return addr.Unmap(), addr.IsValid()
+}
+
+// UntrustedPrefix parses the elements in the given line in reverse
+// and yields the elements that could have been supplied by the
+// client: the first element of which the for node is not in one of
+// the trusted prefixes and all elements before it. Elements are
+// yielded in reverse, starting at that first untrusted element.
+// The error yielded is of type [*ParseError].
+func UntrustedPrefix(line string, trusted []netip.Prefix) iter.Seq2[*Element, error] {
+	return func(yield func(*Element, error) bool) {
+		boundary := false
+		for elem, err := range Parse(line, true) {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !boundary && isTrusted(elem.For, trusted) {
+				continue
+			}
+			boundary = true
+			if !yield(elem, nil) {
+				return
+			}
+		}
+	}
+}
+
+// isTrusted reports whether node n is an IP address contained
+// in one of the trusted prefixes.
+func isTrusted(n Node, trusted []netip.Prefix) bool {
+	addr, _, _ := n.AddrPort()
+	return addr.IsValid() && containsAddr(trusted, addr.Unmap())
+}
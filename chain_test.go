@@ -0,0 +1,481 @@
+package forwarded
+
+import (
+	"net/netip"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+var trustedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("2001:db8:cafe::/48"),
+}
+
+func parseChain(t testing.TB, line string) Chain {
+	t.Helper()
+	var c Chain
+	for elem, err := range Parse(line, false) {
+		if err != nil {
+			t.Fatalf("Parse(%q) error: %v", line, err)
+		}
+		c = append(c, elem)
+	}
+	return c
+}
+
+func TestChainString(t *testing.T) {
+	line := `for=192.0.2.43, for="[2001:db8:cafe::17]";proto=https, for=unknown`
+	if got := parseChain(t, line).String(); got != line {
+		t.Errorf("String() = %q, want: %q", got, line)
+	}
+	if got := Chain(nil).String(); got != "" {
+		t.Errorf("Chain(nil).String() = %q, want empty string", got)
+	}
+}
+
+func TestChainSize(t *testing.T) {
+	for _, c := range parseTests {
+		chain := parseChain(t, c.in)
+		for _, e := range chain {
+			if got, want := e.Size(), len(e.String()); got != want {
+				t.Errorf("%s: Element.Size() = %d, want: %d", c.name, got, want)
+			}
+		}
+		if got, want := chain.Size(), len(chain.String()); got != want {
+			t.Errorf("%s: Chain.Size() = %d, want: %d", c.name, got, want)
+		}
+	}
+
+	e := Element{Extra: []Parameter{{"key", `a"b\c`}}}
+	if got, want := e.Size(), len(e.String()); got != want {
+		t.Errorf("Element.Size() = %d, want: %d", got, want)
+	}
+	if got := (Element{}).Size(); got != 0 {
+		t.Errorf("Element{}.Size() = %d, want: 0", got)
+	}
+}
+
+func TestChainObfuscateAddrs(t *testing.T) {
+	line := `for=192.0.2.43;proto=https;host=example.com, for="192.0.2.43:4711";by="[2001:db8:cafe::17]:80", for=_gazonk;by=unknown;key=value`
+	chain := parseChain(t, line)
+	before := chain.String()
+	obf := &Obfuscator{Key: []byte("secret")}
+	got := chain.ObfuscateAddrs(obf)
+
+	if len(got) != len(chain) {
+		t.Fatalf("ObfuscateAddrs() returned %d elements, want: %d", len(got), len(chain))
+	}
+	if got.String() == before || chain.String() != before {
+		t.Fatalf("ObfuscateAddrs() = %q, want obfuscated copy", got)
+	}
+
+	client := obf.Node("192.0.2.43")
+	if got[0].For != client || got[1].For != client {
+		t.Errorf("for nodes = %q, %q, want both: %q", got[0].For, got[1].For, client)
+	}
+	if by := obf.Node("[2001:db8:cafe::17]"); got[1].By != by {
+		t.Errorf("by node = %q, want: %q", got[1].By, by)
+	}
+	if got[0].Proto != "https" || got[0].Host != "example.com" {
+		t.Errorf("proto and host = %q, %q, want: https, example.com", got[0].Proto, got[0].Host)
+	}
+	if got[2].For != "_gazonk" || got[2].By != "unknown" || !reflect.DeepEqual(got[2].Extra, chain[2].Extra) {
+		t.Errorf("element = %v, want: %v", got[2], chain[2])
+	}
+}
+
+func TestChainProtoConflict(t *testing.T) {
+	cases := []struct {
+		line string
+		want bool
+	}{
+		{"for=192.0.2.43", false},
+		{"proto=https, proto=https", false},
+		{"proto=https, proto=http, proto=http", false},
+		{"proto=HTTPS, for=10.0.0.1, proto=http", false},
+		{"proto=http, proto=https", true},
+		{"proto=https, proto=http, for=10.0.0.1, proto=https", true},
+	}
+
+	for _, c := range cases {
+		if got := parseChain(t, c.line).ProtoConflict(); got != c.want {
+			t.Errorf("ProtoConflict(%q) = %v, want: %v", c.line, got, c.want)
+		}
+	}
+}
+
+func TestChainExtraValues(t *testing.T) {
+	chain := parseChain(t, "for=192.0.2.43;trace=a, for=10.0.0.1, for=10.0.0.2;Trace=c;trace=d;other=x")
+
+	if got, want := chain.ExtraValues("TRACE"), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtraValues(%q) = %q, want: %q", "TRACE", got, want)
+	}
+	if got := chain.ExtraValues("missing"); got != nil {
+		t.Errorf("ExtraValues(%q) = %q, want: nil", "missing", got)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	a := &Element{For: "192.0.2.43", Extra: []Parameter{{"x", "1"}, {"y", "2"}}}
+	b := &Element{For: "192.0.2.43", Extra: []Parameter{{"y", "2"}, {"x", "1"}}}
+	c := &Element{For: "198.51.100.17"}
+	cases := []struct {
+		in   []*Element
+		want []*Element
+	}{
+		{nil, nil},
+		{[]*Element{a}, []*Element{a}},
+		{[]*Element{a, a.Clone()}, []*Element{a}},
+		{[]*Element{a, b}, []*Element{a, b}},
+		{[]*Element{a, a.Clone(), b, b.Clone(), b, c}, []*Element{a, b, c}},
+		{[]*Element{a, c, a}, []*Element{a, c, a}},
+	}
+
+	for _, c := range cases {
+		in := slices.Clone(c.in)
+		got := Dedup(in)
+		if !slices.Equal(got, c.want) {
+			t.Errorf("Dedup(%v) = %v, want: %v", c.in, got, c.want)
+		}
+		if len(got) > 0 && &got[0] != &in[0] {
+			t.Errorf("Dedup(%v) allocated a new slice", c.in)
+		}
+	}
+}
+
+func TestChainCanonicalize(t *testing.T) {
+	a := parseChain(t, `For="[2001:DB8:CAFE:0::17]:04711";Proto=HTTPS;Host=Example.COM;z=1;B=2, for=192.0.2.43;b=3`)
+	b := parseChain(t, `for="[2001:db8:cafe::17]:4711";b=2;z=1;proto=https;host=example.com,for=192.0.2.43;B=3`)
+
+	want := `for="[2001:db8:cafe::17]:4711";proto=https;host=example.com;b=2;z=1, for=192.0.2.43;b=3`
+	for _, c := range []Chain{a, b} {
+		if got := c.Canonicalize().String(); got != want {
+			t.Errorf("Canonicalize() = %q, want: %q", got, want)
+		}
+	}
+
+	before := a.String()
+	a.Canonicalize()
+	if a.String() != before {
+		t.Errorf("Canonicalize() modified chain: %q, want: %q", a, before)
+	}
+
+	for _, n := range []Node{"unknown", "_GAZONK", "_gazonk:_PORT", "192.0.2.43:_port"} {
+		if got := canonicalNode(n); got != n {
+			t.Errorf("canonicalNode(%q) = %q, want unchanged", n, got)
+		}
+	}
+}
+
+func TestChainTailN(t *testing.T) {
+	chain := parseChain(t, "for=192.0.2.43, for=198.51.100.17, for=10.0.0.1;proto=https, for=10.0.0.2")
+	before := chain.String()
+
+	if got := chain.TailN(4, true); got.String() != before {
+		t.Errorf("TailN(4, true) = %q, want: %q", got, before)
+	}
+	if got := chain.TailN(2, false).String(); got != "for=10.0.0.1;proto=https, for=10.0.0.2" {
+		t.Errorf("TailN(2, false) = %q", got)
+	}
+	if _, ok := chain.TailN(2, false).Truncated(); ok {
+		t.Error("TailN(2, false).Truncated() returned true")
+	}
+	if _, ok := chain.Truncated(); ok {
+		t.Error("Truncated() returned true for full chain")
+	}
+
+	tail := chain.TailN(2, true)
+	if got, want := tail.String(), "for=10.0.0.1;proto=https;_truncated=2, for=10.0.0.2"; got != want {
+		t.Errorf("TailN(2, true) = %q, want: %q", got, want)
+	}
+	if n, ok := tail.Truncated(); n != 2 || !ok {
+		t.Errorf("Truncated() = (%d, %v), want: (2, true)", n, ok)
+	}
+	if chain.String() != before {
+		t.Errorf("TailN() modified chain: %q, want: %q", chain, before)
+	}
+
+	reparsed := parseChain(t, tail.String())
+	if n, ok := reparsed.Truncated(); n != 2 || !ok {
+		t.Errorf("reparsed Truncated() = (%d, %v), want: (2, true)", n, ok)
+	}
+	again := reparsed.TailN(1, true)
+	if got, want := again.String(), "for=10.0.0.2;_truncated=3"; got != want {
+		t.Errorf("TailN(1, true) of truncated chain = %q, want: %q", got, want)
+	}
+}
+
+func TestChainMatchesPath(t *testing.T) {
+	path := []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("2001:db8:cafe::1"),
+	}
+
+	cases := []struct {
+		line string
+		want bool
+	}{
+		{`for=192.0.2.43;by=10.0.0.1, for=10.0.0.1;by="[2001:db8:cafe::1]:443"`, true},
+		{`for=192.0.2.43;by="[2001:db8:cafe::1]", for=10.0.0.1;by=10.0.0.1`, false},
+		{`for=192.0.2.43;by=10.0.0.1`, false},
+		{`for=192.0.2.43;by=10.0.0.1, for=10.0.0.1;by=_gazonk`, false},
+		{`for=198.51.100.17;by=_corp, for=192.0.2.43;by=10.0.0.1, for=10.0.0.1;by="[2001:db8:cafe::1]"`, true},
+		{`for=198.51.100.17;by=10.0.0.1, for=192.0.2.43;by="[2001:db8:cafe::1]", for=10.0.0.1;by=_x`, false},
+		{`for=192.0.2.43;by=10.0.0.1, for=10.0.0.1`, false},
+	}
+
+	for _, c := range cases {
+		if got := parseChain(t, c.line).MatchesPath(path); got != c.want {
+			t.Errorf("MatchesPath(%q) = %v, want: %v", c.line, got, c.want)
+		}
+	}
+}
+
+func TestChainHosts(t *testing.T) {
+	cases := []struct {
+		line string
+		want []string
+	}{
+		{"for=192.0.2.43", nil},
+		{"for=192.0.2.43;host=Example.com, for=10.0.0.1, for=10.0.0.2;host=example.com", []string{"example.com", "example.com"}},
+		{`for=192.0.2.43;host=example.com, for=10.0.0.1;host="internal.example:8080"`, []string{"example.com", "internal.example:8080"}},
+	}
+
+	for _, c := range cases {
+		if got := parseChain(t, c.line).Hosts(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Hosts(%q) = %q, want: %q", c.line, got, c.want)
+		}
+	}
+}
+
+func TestChainExceedsHops(t *testing.T) {
+	chain := parseChain(t, "for=192.0.2.43, for=10.0.0.1, for=10.0.0.2")
+	if chain.ExceedsHops(3) {
+		t.Error("ExceedsHops(3) = true for 3 elements")
+	}
+	if !chain.ExceedsHops(2) {
+		t.Error("ExceedsHops(2) = false for 3 elements")
+	}
+	if Chain(nil).ExceedsHops(0) {
+		t.Error("Chain(nil).ExceedsHops(0) = true")
+	}
+}
+
+func TestChainAnyObfuscatedBy(t *testing.T) {
+	cases := []struct {
+		line string
+		want bool
+	}{
+		{"for=192.0.2.43;by=10.0.0.1, for=10.0.0.1;by=10.0.0.2", false},
+		{"for=192.0.2.43;by=unknown", false},
+		{"for=_hidden", false},
+		{"for=192.0.2.43;by=_gazonk", true},
+		{"for=192.0.2.43;by=unknown, for=10.0.0.1;by=_SEVKISEK", true},
+		{`for=192.0.2.43;by="_gazonk:_port"`, true},
+	}
+
+	for _, c := range cases {
+		if got := parseChain(t, c.line).AnyObfuscatedBy(); got != c.want {
+			t.Errorf("AnyObfuscatedBy(%s) = %t, want %t", c.line, got, c.want)
+		}
+	}
+	if Chain(nil).AnyObfuscatedBy() {
+		t.Error("Chain(nil).AnyObfuscatedBy() = true")
+	}
+}
+
+func TestChainFirstUntrusted(t *testing.T) {
+	cases := []struct {
+		line string
+		want Node
+		ok   bool
+	}{
+		{"for=10.0.0.1, for=10.0.0.2", "", false},
+		{"for=192.0.2.43, for=198.51.100.17", "198.51.100.17", true},
+		{"for=192.0.2.43, for=198.51.100.17, for=10.0.0.1", "198.51.100.17", true},
+		{`for=192.0.2.43, for=unknown, for="[2001:db8:cafe::17]"`, "unknown", true},
+	}
+
+	for _, c := range cases {
+		elem, ok := parseChain(t, c.line).FirstUntrusted(trustedPrefixes)
+		var got Node
+		if elem != nil {
+			got = elem.For
+		}
+		if got != c.want || ok != c.ok {
+			t.Errorf("FirstUntrusted(%q) = (%q, %v), want: (%q, %v)", c.line, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestChainTrustedSuffix(t *testing.T) {
+	cases := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"trusted", "for=10.0.0.1, for=10.0.0.2", "for=10.0.0.1, for=10.0.0.2"},
+		{"untrusted", "for=192.0.2.43, for=198.51.100.17", ""},
+		{"mixed", `for=10.0.0.3, for=192.0.2.43, for=10.0.0.1, for="[2001:db8:cafe::17]:4711"`, `for=10.0.0.1, for="[2001:db8:cafe::17]:4711"`},
+	}
+
+	for _, c := range cases {
+		got := parseChain(t, c.line).TrustedSuffix(trustedPrefixes).String()
+		if got != c.want {
+			t.Errorf("%s: TrustedSuffix() = %q, want: %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestChainTrimSpoofed(t *testing.T) {
+	cases := []struct {
+		line string
+		want string
+	}{
+		{"for=192.0.2.43, for=10.0.0.1", "for=192.0.2.43, for=10.0.0.1"},
+		{"for=10.0.0.9, for=203.0.113.1, for=192.0.2.43, for=10.0.0.1", "for=192.0.2.43, for=10.0.0.1"},
+		{`for=_forged;proto=https, for=192.0.2.43;proto=http, for=10.0.0.1`, "for=192.0.2.43;proto=http, for=10.0.0.1"},
+		{"for=10.0.0.2, for=10.0.0.1", "for=10.0.0.2, for=10.0.0.1"},
+		{"for=192.0.2.43", "for=192.0.2.43"},
+	}
+
+	for _, c := range cases {
+		if got := parseChain(t, c.line).TrimSpoofed(trustedPrefixes).String(); got != c.want {
+			t.Errorf("TrimSpoofed(%q) = %q, want: %q", c.line, got, c.want)
+		}
+	}
+}
+
+func TestChainEdgeHop(t *testing.T) {
+	cases := []struct {
+		line string
+		want Node
+		ok   bool
+	}{
+		{"for=192.0.2.43, for=10.0.0.1", "10.0.0.1", true},
+		{"for=192.0.2.43, for=10.0.0.1, for=10.0.0.2", "10.0.0.1", true},
+		{`for=192.0.2.43, for="[2001:db8:cafe::1]", for=10.0.0.2, for=10.0.0.3`, "[2001:db8:cafe::1]", true},
+		{"for=10.0.0.4, for=192.0.2.43, for=10.0.0.1", "10.0.0.1", true},
+		{"for=192.0.2.43, for=198.51.100.17", "", false},
+		{"for=10.0.0.1, for=10.0.0.2", "", false},
+	}
+
+	for _, c := range cases {
+		elem, ok := parseChain(t, c.line).EdgeHop(trustedPrefixes)
+		var got Node
+		if elem != nil {
+			got = elem.For
+		}
+		if got != c.want || ok != c.ok {
+			t.Errorf("EdgeHop(%q) = (%q, %v), want: (%q, %v)", c.line, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestChainClientInAny(t *testing.T) {
+	partners := []netip.Prefix{
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("192.0.2.0/24"),
+	}
+
+	cases := []struct {
+		line string
+		want netip.Prefix
+		ok   bool
+	}{
+		{"for=192.0.2.43, for=10.0.0.1", partners[1], true},
+		{`for=203.0.113.1, for="198.51.100.17:4711", for=10.0.0.1`, partners[0], true},
+		{"for=192.0.2.43, for=203.0.113.1, for=10.0.0.1", netip.Prefix{}, false},
+		{"for=192.0.2.43, for=unknown, for=10.0.0.1", netip.Prefix{}, false},
+		{"for=10.0.0.2, for=10.0.0.1", netip.Prefix{}, false},
+	}
+
+	for _, c := range cases {
+		got, ok := parseChain(t, c.line).ClientInAny(trustedPrefixes, partners)
+		if got != c.want || ok != c.ok {
+			t.Errorf("ClientInAny(%q) = (%v, %v), want: (%v, %v)", c.line, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestUntrustedPrefix(t *testing.T) {
+	cases := []struct {
+		line string
+		want []Node
+	}{
+		{"for=10.0.0.1, for=10.0.0.2", nil},
+		{"for=192.0.2.43", []Node{"192.0.2.43"}},
+		{"for=192.0.2.43, for=10.0.0.1", []Node{"192.0.2.43"}},
+		{"for=192.0.2.43, for=198.51.100.17, for=10.0.0.1, for=10.0.0.2", []Node{"198.51.100.17", "192.0.2.43"}},
+		{"for=192.0.2.43, for=10.0.0.3, for=unknown, for=10.0.0.2", []Node{"unknown", "10.0.0.3", "192.0.2.43"}},
+	}
+
+	for _, c := range cases {
+		var got []Node
+		for elem, err := range UntrustedPrefix(c.line, trustedPrefixes) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, elem.For)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("UntrustedPrefix(%q) = %q, want: %q", c.line, got, c.want)
+		}
+	}
+
+	for _, err := range UntrustedPrefix("for, for=10.0.0.1", trustedPrefixes) {
+		if _, ok := err.(*ParseError); !ok {
+			t.Errorf("UntrustedPrefix(invalid) error = %v, want *ParseError", err)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	cases := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{"", " "}, ""},
+		{
+			[]string{
+				`for=192.0.2.43;proto=https`,
+				`For="192.0.2.43" ; PROTO=HTTPS`,
+				`proto="https";for=192.0.2.43`,
+			},
+			"for=192.0.2.43;proto=https",
+		},
+		{
+			[]string{
+				`for="[2001:DB8:cafe:0::17]:04711", by=_proxy;Key=v;a="x,y"`,
+				`for = "[2001:db8:cafe::17]:4711",by="_proxy";a="x,y";key="v"`,
+			},
+			`for="[2001:db8:cafe::17]:4711", by=_proxy;a="x,y";key=v`,
+		},
+		{
+			[]string{
+				`host=Example.COM;for=unknown`,
+				`for="unknown";host="example.com"`,
+			},
+			"for=unknown;host=example.com",
+		},
+	}
+
+	for _, c := range cases {
+		for _, in := range c.in {
+			got, err := Canonicalize(in)
+			if err != nil {
+				t.Errorf("Canonicalize(%s) error: %v", in, err)
+				continue
+			}
+			if got != c.want {
+				t.Errorf("Canonicalize(%s) = %s, want: %s", in, got, c.want)
+			}
+		}
+	}
+
+	_, err := Canonicalize("for=192.0.2.43, for")
+	if pe, ok := err.(*ParseError); !ok || pe.Msg != `no "=" found in` {
+		t.Errorf("Canonicalize(invalid) error = %v, want: %q", err, `no "=" found in`)
+	}
+}
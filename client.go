@@ -0,0 +1,196 @@
+package forwarded
+
+import (
+	"net/http"
+	"net/netip"
+)
+
+const xForwardedFor = "X-Forwarded-For"
+
+// RealIPPreferForwarded returns the address of the client that sent
+// request r, using the following order of precedence:
+//  1. If the address in r.RemoteAddr is not in one of the trusted
+//     prefixes, it is returned and no header is considered, as
+//     these could have been set by the client.
+//  2. The for node of [Chain.FirstUntrusted] of the Forwarded
+//     header fields, if they parse and this node is an IP address.
+//  3. The rightmost address in the X-Forwarded-For header fields
+//     that is not in one of the trusted prefixes, if all addresses
+//     up to and including it are IP addresses.
+//  4. The address in r.RemoteAddr.
+//
+// It returns false if r.RemoteAddr is not an IP address.
+func RealIPPreferForwarded(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	remote, ok := remoteAddr(r)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	if !containsAddr(trusted, remote) {
+		return remote, true
+	}
+
+	if elems, err := ParseAllRequest(r, false); err == nil {
+		if addr, ok := Chain(elems).clientAddr(trusted); ok {
+			return addr, true
+		}
+	}
+
+	if addr, ok := xffClientAddr(headerLine(r.Header, xForwardedFor), trusted); ok {
+		return addr, true
+	}
+
+	return remote, true
+}
+
+// ClientIP returns the address of the client in the Forwarded
+// line. The elements are walked from the rightmost, which was
+// added by the closest proxy, and the first for node of which
+// the address is not trusted is returned. It returns false if
+// the line does not parse, all addresses are trusted or a for
+// node up to and including the client is not an IP address.
+func ClientIP(line string, trusted func(netip.Addr) bool) (netip.Addr, bool) {
+	addr, _, ok := clientNode(line, trusted)
+	return addr, ok
+}
+
+// VerifyChain returns the address of the client in the Forwarded
+// line received from the directly connected peer at address
+// remote. The line is only believed if remote is trusted, as
+// otherwise it could have been set by the client. Then the
+// elements are walked from the rightmost inward like [ClientIP]:
+// every for node must be an IP address and the first one that is
+// not trusted is the client. It returns false if remote is not
+// trusted, the line does not parse, all addresses are trusted or
+// a for node up to and including the client is not an IP address.
+func VerifyChain(line string, remote netip.Addr, trusted func(netip.Addr) bool) (client netip.Addr, ok bool) {
+	if !remote.IsValid() || !trusted(remote.Unmap()) {
+		return netip.Addr{}, false
+	}
+	return ClientIP(line, trusted)
+}
+
+// clientNode returns the address and node port of the client
+// in the Forwarded line, as described at [ClientIP].
+func clientNode(line string, trusted func(netip.Addr) bool) (netip.Addr, NodePort, bool) {
+	for elem, err := range Parse(line, true) {
+		if err != nil {
+			return netip.Addr{}, "", false
+		}
+		addr, port, _ := elem.For.AddrPort()
+		if !addr.IsValid() {
+			return netip.Addr{}, "", false
+		}
+		addr = addr.Unmap()
+		if !trusted(addr) {
+			return addr, port, true
+		}
+	}
+	return netip.Addr{}, "", false
+}
+
+// ClientIPRequest is like [ClientIP], but uses all Forwarded
+// header fields in request r.
+func ClientIPRequest(r *http.Request, trusted func(netip.Addr) bool) (netip.Addr, bool) {
+	return ClientIP(headerLine(r.Header, header), trusted)
+}
+
+// Middleware returns middleware that sets RemoteAddr of a request
+// to the address of the client in the Forwarded header fields, as
+// found by [ClientIPRequest]. The port of the client is used if it
+// is numeric. If the for node has no port or an obfuscated one,
+// RemoteAddr is intentionally set to the address with port 0, like
+// "192.0.2.43:0", so it still parses as an address and port.
+// RemoteAddr is left unchanged if its address is not trusted,
+// because then the header could have been set by the client, or
+// if no client address is found. The handler is passed a shallow
+// copy of the request.
+func Middleware(trusted func(netip.Addr) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if remote, ok := remoteAddr(r); ok && trusted(remote) {
+				addr, np, ok := clientNode(headerLine(r.Header, header), trusted)
+				if ok {
+					port, _ := np.Uint16()
+					r2 := *r
+					r2.RemoteAddr = netip.AddrPortFrom(addr, port).String()
+					r = &r2
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIPEither returns the address of the client in the
+// Forwarded line, for proxies that append elements in either
+// order. It uses the following order of precedence:
+//  1. The for node of [Chain.FirstUntrusted], if it is a public
+//     IP address. This is correct if the elements are appended.
+//  2. The for node of the leftmost element that is a public IP
+//     address not in one of the trusted prefixes. This covers
+//     proxies that prepend elements.
+//
+// An address is public if it is a global unicast address and not
+// a private address. It returns false if the line does not parse
+// or no address is found. Because the second step trusts elements
+// that could have been supplied by the client, it should only be
+// used if the infrastructure requires it.
+func ClientIPEither(line string, trusted []netip.Prefix) (netip.Addr, bool) {
+	elems, err := ParseAll(line, false)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	c := Chain(elems)
+	if addr, ok := c.clientAddr(trusted); ok && isPublic(addr) {
+		return addr, true
+	}
+	for _, e := range c {
+		addr, _, _ := e.For.AddrPort()
+		addr = addr.Unmap()
+		if isPublic(addr) && !containsAddr(trusted, addr) {
+			return addr, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// isPublic reports whether addr is a global unicast address
+// that is not private.
+func isPublic(addr netip.Addr) bool {
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
+
+// remoteAddr returns the IP address in r.RemoteAddr.
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	addr, _, _ := Node(r.RemoteAddr).AddrPort()
+	return addr.Unmap(), addr.IsValid()
+}
+
+// xffClientAddr returns the rightmost address in X-Forwarded-For
+// line that is not in one of the trusted prefixes. It returns
+// false if an entry up to that address is not an IP address.
+func xffClientAddr(line string, trusted []netip.Prefix) (netip.Addr, bool) {
+	for entry := range reverseSplitSeq(line, ",") {
+		addr, _, _ := Node(trimOWS(entry)).AddrPort()
+		if !addr.IsValid() {
+			return netip.Addr{}, false
+		}
+		addr = addr.Unmap()
+		if !containsAddr(trusted, addr) {
+			return addr, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// containsAddr reports whether addr is contained in one of
+// the prefixes.
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+package forwarded
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func TestRealIPPreferForwarded(t *testing.T) {
+	cases := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		xff       []string
+		want      string
+		ok        bool
+	}{
+		{
+			name:      "remote/untrusted",
+			remote:    "192.0.2.43:4711",
+			forwarded: []string{"for=198.51.100.17"},
+			want:      "192.0.2.43",
+			ok:        true,
+		},
+		{
+			name:      "forwarded",
+			remote:    "10.0.0.1:4711",
+			forwarded: []string{"for=192.0.2.43, for=198.51.100.17", "for=10.0.0.2"},
+			xff:       []string{"203.0.113.1"},
+			want:      "198.51.100.17",
+			ok:        true,
+		},
+		{
+			name:      "forwarded/ipv6",
+			remote:    "[2001:db8:cafe::1]:4711",
+			forwarded: []string{`for="[2001:db8::17]:4711"`},
+			want:      "2001:db8::17",
+			ok:        true,
+		},
+		{
+			name:      "xff/forwarded-unknown",
+			remote:    "10.0.0.1:4711",
+			forwarded: []string{"for=unknown"},
+			xff:       []string{"203.0.113.1, 10.0.0.2"},
+			want:      "203.0.113.1",
+			ok:        true,
+		},
+		{
+			name:      "xff/forwarded-invalid",
+			remote:    "10.0.0.1:4711",
+			forwarded: []string{"for"},
+			xff:       []string{"198.51.100.17", "203.0.113.1"},
+			want:      "203.0.113.1",
+			ok:        true,
+		},
+		{
+			name:   "xff/empty-field",
+			remote: "10.0.0.1:4711",
+			xff:    []string{"203.0.113.1", ""},
+			want:   "203.0.113.1",
+			ok:     true,
+		},
+		{
+			name:   "xff",
+			remote: "10.0.0.1:4711",
+			xff:    []string{"203.0.113.1"},
+			want:   "203.0.113.1",
+			ok:     true,
+		},
+		{
+			name:   "remote/xff-invalid",
+			remote: "10.0.0.1:4711",
+			xff:    []string{"203.0.113.1, garbage"},
+			want:   "10.0.0.1",
+			ok:     true,
+		},
+		{
+			name:      "remote/all-trusted",
+			remote:    "10.0.0.1:4711",
+			forwarded: []string{"for=10.0.0.3"},
+			xff:       []string{"10.0.0.2"},
+			want:      "10.0.0.1",
+			ok:        true,
+		},
+		{
+			name:   "remote/invalid",
+			remote: "@",
+		},
+	}
+
+	for _, c := range cases {
+		r := &http.Request{RemoteAddr: c.remote, Header: http.Header{}}
+		for _, v := range c.forwarded {
+			r.Header.Add("Forwarded", v)
+		}
+		for _, v := range c.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+
+		got, ok := RealIPPreferForwarded(r, trustedPrefixes)
+		var want netip.Addr
+		if c.want != "" {
+			want = netip.MustParseAddr(c.want)
+		}
+		if got != want || ok != c.ok {
+			t.Errorf("%s: RealIPPreferForwarded() = (%v, %v), want: (%v, %v)", c.name, got, ok, want, c.ok)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := func(addr netip.Addr) bool {
+		return containsAddr(trustedPrefixes, addr) || addr.IsLoopback()
+	}
+
+	cases := []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{"for=192.0.2.43", "192.0.2.43", true},
+		{"for=198.51.100.17, for=192.0.2.43, for=10.0.0.2, for=127.0.0.1", "192.0.2.43", true},
+		{`for="[2001:db8::17]:4711", for="[2001:db8:cafe::1]"`, "2001:db8::17", true},
+		{`for="[::ffff:192.0.2.43]", for=10.0.0.1`, "192.0.2.43", true},
+		{"for=192.0.2.43, for=unknown, for=10.0.0.1", "", false},
+		{"for=192.0.2.43, for=_hidden", "", false},
+		{"for=10.0.0.1, for=10.0.0.2", "", false},
+		{"for=192.0.2.43, for", "", false},
+		{"", "", false},
+	}
+
+	for _, c := range cases {
+		got, ok := ClientIP(c.line, trusted)
+		var want netip.Addr
+		if c.want != "" {
+			want = netip.MustParseAddr(c.want)
+		}
+		if got != want || ok != c.ok {
+			t.Errorf("ClientIP(%s) = %v, %v, want: %v, %v", c.line, got, ok, want, c.ok)
+		}
+	}
+
+	r := &http.Request{Header: http.Header{}}
+	r.Header.Add("Forwarded", "for=192.0.2.43")
+	r.Header.Add("Forwarded", "for=10.0.0.1")
+	if got, ok := ClientIPRequest(r, trusted); got != netip.MustParseAddr("192.0.2.43") || !ok {
+		t.Errorf("ClientIPRequest() = %v, %v, want: 192.0.2.43, true", got, ok)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	trusted := func(addr netip.Addr) bool {
+		return containsAddr(trustedPrefixes, addr)
+	}
+
+	line := "for=198.51.100.17, for=192.0.2.43, for=10.0.0.2"
+	cases := []struct {
+		line   string
+		remote netip.Addr
+		want   string
+		ok     bool
+	}{
+		{line, netip.MustParseAddr("10.0.0.1"), "192.0.2.43", true},
+		{line, netip.MustParseAddr("::ffff:10.0.0.1"), "192.0.2.43", true},
+		{`for="[2001:db8::17]:4711"`, netip.MustParseAddr("2001:db8:cafe::1"), "2001:db8::17", true},
+		{line, netip.MustParseAddr("192.0.2.60"), "", false},
+		{line, netip.Addr{}, "", false},
+		{"for=192.0.2.43, for=_hidden", netip.MustParseAddr("10.0.0.1"), "", false},
+		{"for=10.0.0.3, for=10.0.0.2", netip.MustParseAddr("10.0.0.1"), "", false},
+		{"for=192.0.2.43, for", netip.MustParseAddr("10.0.0.1"), "", false},
+		{"", netip.MustParseAddr("10.0.0.1"), "", false},
+	}
+
+	for _, c := range cases {
+		got, ok := VerifyChain(c.line, c.remote, trusted)
+		var want netip.Addr
+		if c.want != "" {
+			want = netip.MustParseAddr(c.want)
+		}
+		if got != want || ok != c.ok {
+			t.Errorf("VerifyChain(%s, %v) = %v, %v, want: %v, %v", c.line, c.remote, got, ok, want, c.ok)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	trusted := func(addr netip.Addr) bool {
+		return containsAddr(trustedPrefixes, addr)
+	}
+
+	cases := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{
+			name:      "multi-hop",
+			remote:    "10.0.0.1:4711",
+			forwarded: []string{`for=198.51.100.17, for="192.0.2.43:47011"`, "for=10.0.0.2"},
+			want:      "192.0.2.43:47011",
+		},
+		{
+			name:      "no-port",
+			remote:    "[2001:db8:cafe::1]:4711",
+			forwarded: []string{`for="[2001:db8::17]", for=10.0.0.2`},
+			want:      "[2001:db8::17]:0",
+		},
+		{
+			name:      "obfuscated-port",
+			remote:    "10.0.0.1:4711",
+			forwarded: []string{`for="192.0.2.43:_port"`},
+			want:      "192.0.2.43:0",
+		},
+		{
+			name:      "remote-untrusted",
+			remote:    "203.0.113.60:4711",
+			forwarded: []string{"for=192.0.2.43"},
+			want:      "203.0.113.60:4711",
+		},
+		{
+			name:      "all-trusted",
+			remote:    "10.0.0.1:4711",
+			forwarded: []string{"for=10.0.0.2, for=10.0.0.3"},
+			want:      "10.0.0.1:4711",
+		},
+		{
+			name:      "unknown",
+			remote:    "10.0.0.1:4711",
+			forwarded: []string{"for=192.0.2.43, for=unknown"},
+			want:      "10.0.0.1:4711",
+		},
+		{
+			name:   "no-header",
+			remote: "10.0.0.1:4711",
+			want:   "10.0.0.1:4711",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got string
+			h := Middleware(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			r := &http.Request{RemoteAddr: c.remote, Header: http.Header{}}
+			for _, f := range c.forwarded {
+				r.Header.Add("Forwarded", f)
+			}
+			h.ServeHTTP(nil, r)
+			if got != c.want {
+				t.Errorf("RemoteAddr = %s, want: %s", got, c.want)
+			}
+			if r.RemoteAddr != c.remote {
+				t.Errorf("original RemoteAddr = %s, want unchanged %s", r.RemoteAddr, c.remote)
+			}
+		})
+	}
+}
+
+func TestClientIPEither(t *testing.T) {
+	cases := []struct {
+		name string
+		line string
+		want string
+		ok   bool
+	}{
+		{"appended", "for=192.0.2.43, for=10.0.0.2, for=10.0.0.1", "192.0.2.43", true},
+		{"appended/spoofed", "for=198.51.100.17, for=192.0.2.43, for=10.0.0.1", "192.0.2.43", true},
+		{"prepended", "for=10.0.0.1, for=10.0.0.2, for=192.0.2.43", "192.0.2.43", true},
+		{"prepended/private", "for=10.0.0.1, for=192.0.2.43, for=192.168.1.10", "192.0.2.43", true},
+		{"prepended/unknown", "for=10.0.0.1, for=192.0.2.43, for=unknown", "192.0.2.43", true},
+		{"ipv6", `for="[2001:db8:cafe::1]", for="[2001:db8::17]:4711"`, "2001:db8::17", true},
+		{"trusted", "for=10.0.0.1, for=10.0.0.2", "", false},
+		{"private", "for=192.168.1.10, for=127.0.0.1, for=_hidden", "", false},
+		{"invalid", "for=192.0.2.43, for", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := ClientIPEither(c.line, trustedPrefixes)
+			var want netip.Addr
+			if c.want != "" {
+				want = netip.MustParseAddr(c.want)
+			}
+			if got != want || ok != c.ok {
+				t.Errorf("ClientIPEither(%s) = %v, %v, want: %v, %v", c.line, got, ok, want, c.ok)
+			}
+		})
+	}
+}
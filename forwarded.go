@@ -2,74 +2,191 @@
 package forwarded
 
 import (
+	"errors"
 	"fmt"
 	"iter"
 	"net"
 	"net/http"
 	"net/netip"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 )
 
 // Parse parses elements in the given line. If reverse
-// is true, the elements are parsed in reverse.
+// is true, the elements are parsed in reverse. Commas and
+// semicolons inside quoted-strings do not separate elements
+// and parameters. Empty elements, such as caused by stray
+// commas, are skipped. Optional whitespace around "=" is
+// trimmed, but whitespace inside a value is kept. Every element yielded
+// is newly allocated and may be retained.
 // The error returned is of type [*ParseError].
 func Parse(line string, reverse bool) iter.Seq2[*Element, error] {
-	splitSeq := strings.SplitSeq
+	return parse(line, reverse, nil)
+}
+
+// ParseShared is like [Parse], but yields the same element for
+// every element in the line, which avoids an allocation per
+// element. The element and its Extra slice are only valid until
+// the next iteration; use [Element.Clone] to retain it.
+func ParseShared(line string, reverse bool) iter.Seq2[*Element, error] {
+	return parse(line, reverse, &sharedOptions)
+}
+
+// ParseLimit is like [Parse], but parses at most maxElements
+// elements. If the line contains more elements, a [*ParseError]
+// is yielded instead of the next element. If maxElements <= 0,
+// the number of elements is not limited.
+// The error returned is of type [*ParseError].
+func ParseLimit(line string, reverse bool, maxElements int) iter.Seq2[*Element, error] {
+	if maxElements <= 0 {
+		return Parse(line, reverse)
+	}
+	return parse(line, reverse, &options{maxElements: maxElements})
+}
+
+func parse(line string, reverse bool, o *options) iter.Seq2[*Element, error] {
+	splitSeq := splitUnquotedSeq
 	if reverse {
-		splitSeq = reverseSplitSeq
+		splitSeq = reverseSplitUnquotedSeq
 	}
 
 	return func(yield func(*Element, error) bool) {
-		for elem := range splitSeq(line, ",") {
-			var e Element
-
-			for i := strings.IndexByte(elem, ';'); i != -1; i = strings.IndexByte(elem, ';') {
-				err := parsePair(&e, trimOWS(elem[:i]))
-				if err != nil {
-					yield(nil, err)
-					return
-				}
-				elem = elem[i+1:]
+		n := 0
+		var prev *Element
+		pos := 0
+		if reverse {
+			pos = len(line) + 1
+		}
+		for elem := range splitSeq(line, ',') {
+			off := pos
+			if reverse {
+				off = pos - len(elem) - 1
+				pos = off
+			} else {
+				pos += len(elem) + 1
 			}
-			if err := parsePair(&e, trimOWS(elem)); err != nil {
-				yield(nil, err)
+
+			if o.skipElement(o.trim(elem)) {
+				continue
+			}
+			n++
+			if err := o.checkElements(n, o.trim(elem)); err != nil {
+				yield(nil, errorAt(err, off+strings.Index(elem, o.trim(elem))))
 				return
 			}
 
-			if !yield(&e, nil) {
+			e := o.element(prev)
+			prev = e
+			if err := parseElement(e, elem, o); err != nil {
+				o.release(e)
+				yield(nil, errorAt(err, off))
+				return
+			}
+
+			if !yield(e, nil) {
 				return
 			}
 		}
 	}
 }
 
-func parsePair(e *Element, pair string) error {
+// parseElement parses the parameters in elem into element e.
+// The offset of a returned parse error is relative to elem.
+func parseElement(e *Element, elem string, o *options) error {
+	pos := 0
+	for pair := range splitUnquotedSeq(elem, ';') {
+		off := pos
+		pos += len(pair) + 1
+
+		trimmed := o.trim(pair)
+		if o.skip(trimmed) {
+			continue
+		}
+		if err := parsePair(e, trimmed, o); err != nil {
+			return errorAt(err, off+strings.Index(pair, trimmed))
+		}
+	}
+	if len(e.Extra) == 0 {
+		// A reused element has no extra parameters either,
+		// just like an element that is freshly allocated.
+		e.Extra = nil
+	}
+	return nil
+}
+
+// parsePair parses parameter pair into element e.
+// The offset of a returned parse error is relative to pair.
+func parsePair(e *Element, pair string, o *options) error {
 	token, value, found := strings.Cut(pair, "=")
 	if !found {
-		return &ParseError{`no "=" found in`, pair}
+		return &ParseError{Msg: `no "=" found in`, Text: pair, err: ErrNoEquals}
+	}
+	valueAt := len(token) + 1
+	if o == nil || !o.keepEquals {
+		raw := value
+		token, value = o.trim(token), o.trim(value)
+		valueAt += strings.Index(raw, value)
 	}
 
+	if err := o.checkKeyLength(token); err != nil {
+		return err
+	}
 	if !validElementToken(token) {
-		return &ParseError{`invalid token`, token}
+		return &ParseError{Msg: `invalid token`, Text: token, err: ErrInvalidToken}
 	}
-	value, err := unescape(value)
-	if err != nil {
-		return &ParseError{`invalid value`, value}
+	if unterminated(value) {
+		return &ParseError{Msg: `unterminated quoted-string`, Text: value, Offset: valueAt, err: ErrUnterminatedQuote}
+	}
+	if err := o.checkValueLength(token, value); err != nil {
+		return errorAt(err, valueAt)
+	}
+	key := strings.ToLower(token)
+	if key != "proto" || !validBareProto(value) {
+		u, err := unescape(value)
+		if err != nil {
+			return &ParseError{Msg: `invalid value`, Text: value, Offset: valueAt, err: ErrInvalidValue}
+		}
+		value = u
 	}
+	value = o.intern(value)
 
-	switch strings.ToLower(token) {
+	if err := o.checkValue(value); err != nil {
+		return errorAt(err, valueAt)
+	}
+
+	switch key {
 	case "by":
+		if err := o.checkDuplicate(key, e.By != ""); err != nil {
+			return err
+		}
 		e.By = Node(value)
+		return errorAt(o.checkNode(e.By), valueAt)
 	case "for":
+		if err := o.checkDuplicate(key, e.For != ""); err != nil {
+			return err
+		}
 		e.For = Node(value)
+		return errorAt(o.checkNode(e.For), valueAt)
 	case "proto":
+		if err := o.checkDuplicate(key, e.Proto != ""); err != nil {
+			return err
+		}
+		if o != nil && o.lowerProto {
+			value = strings.ToLower(value)
+		}
 		e.Proto = value
+		return errorAt(o.checkProto(e.Proto), valueAt)
 	case "host":
+		if err := o.checkDuplicate(key, e.Host != ""); err != nil {
+			return err
+		}
 		e.Host = value
+		return errorAt(o.checkHost(e.Host), valueAt)
 	default:
-		e.Extra = append(e.Extra, Paramater{
-			Key:   token,
+		e.Extra = append(e.Extra, Parameter{
+			Key:   o.intern(token),
 			Value: value,
 		})
 	}
@@ -95,14 +212,96 @@ func reverseSplitSeq(s, sep string) iter.Seq[string] {
 	}
 }
 
+// SplitElements splits the given line into its elements without
+// parsing them. Commas inside quoted-strings do not separate
+// elements. The elements are returned with surrounding whitespace
+// trimmed. Like [Parse], empty elements caused by stray commas
+// are skipped, so an empty line returns no elements.
+// The error returned is of type [*ParseError].
+func SplitElements(line string) ([]string, error) {
+	if trimOWS(line) == "" {
+		return nil, nil
+	}
+
+	var elems []string
+	pos := 0
+	for elem := range splitUnquotedSeq(line, ',') {
+		if _, open := indexUnquoted(elem, ','); open {
+			return nil, &ParseError{
+				Msg:    `unterminated quoted-string`,
+				Text:   trimOWS(elem),
+				Offset: pos + strings.Index(elem, trimOWS(elem)),
+				err:    ErrUnterminatedQuote,
+			}
+		}
+		if s := trimOWS(elem); s != "" {
+			elems = append(elems, s)
+		}
+		pos += len(elem) + 1
+	}
+	return elems, nil
+}
+
+// Count returns the number of elements in the given line without
+// parsing them. Commas inside quoted-strings do not separate
+// elements. Like [Parse], empty elements caused by stray commas
+// are not counted.
+func Count(line string) int {
+	n := 0
+	for elem := range splitUnquotedSeq(line, ',') {
+		if trimOWS(elem) != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Errors a [*ParseError] unwraps to, depending on the problem.
+// Use [errors.Is] to check for them. Values rejected by the
+// checks of [ParseStrict] unwrap to ErrInvalidValue.
+var (
+	ErrNoEquals           = errors.New(`forwarded: no "=" found`)
+	ErrInvalidToken       = errors.New("forwarded: invalid token")
+	ErrInvalidValue       = errors.New("forwarded: invalid value")
+	ErrUnterminatedQuote  = errors.New("forwarded: unterminated quoted-string")
+	ErrTooManyElements    = errors.New("forwarded: too many elements")
+	ErrTooLong            = errors.New("forwarded: parameter name or value too long")
+	ErrDuplicateParameter = errors.New("forwarded: duplicate parameter")
+)
+
 // ParseError is returned if a line cannot be parsed.
 type ParseError struct {
 	Msg  string
 	Text string
+
+	// Offset is the byte index in the line where the
+	// problem starts, or -1 if the error is not about a
+	// line, like the errors of [Element.Validate].
+	Offset int
+
+	// err is the sentinel error returned by Unwrap.
+	err error
 }
 
 func (e *ParseError) Error() string {
-	return fmt.Sprintf("forwarded: %s %q", e.Msg, e.Text)
+	if e.Offset < 0 {
+		return fmt.Sprintf("forwarded: %s %q", e.Msg, e.Text)
+	}
+	return fmt.Sprintf("forwarded: %s %q at offset %d", e.Msg, e.Text, e.Offset)
+}
+
+// errorAt moves the offset of err by n, if it is a parse error.
+func errorAt(err error, n int) error {
+	if pe, ok := err.(*ParseError); ok {
+		pe.Offset += n
+	}
+	return err
+}
+
+// Unwrap returns the sentinel error of parse error e,
+// or nil if there is none.
+func (e *ParseError) Unwrap() error {
+	return e.err
 }
 
 // Last returns the last element in the given line.
@@ -114,41 +313,451 @@ func Last(line string) (*Element, error) {
 	return nil, nil
 }
 
+// First returns the first element in the given line, which
+// was added by the proxy closest to the client. If the line
+// is empty, the element is nil.
+// The error returned is of type [*ParseError].
+func First(line string) (*Element, error) {
+	if trimOWS(line) == "" {
+		return nil, nil
+	}
+	for elem, err := range Parse(line, false) {
+		return elem, err
+	}
+	return nil, nil
+}
+
+// Nth returns the n-th element in the given line, counting from
+// zero at the first element. A negative n counts from the end,
+// so -1 is the last element. Only the elements up to and
+// including the n-th element are parsed. If the line has no
+// n-th element, the element is nil.
+// The error returned is of type [*ParseError].
+func Nth(line string, n int) (*Element, error) {
+	if trimOWS(line) == "" {
+		return nil, nil
+	}
+	reverse := n < 0
+	if reverse {
+		n = -n - 1
+	}
+	i := 0
+	for elem, err := range ParseShared(line, reverse) {
+		if err != nil {
+			return nil, err
+		}
+		if i == n {
+			return elem.Clone(), nil
+		}
+		i++
+	}
+	return nil, nil
+}
+
+// ForNodes parses the elements in the given line and yields
+// their for nodes. If reverse is true, the elements are parsed
+// in reverse. Elements without a for node are skipped.
+// The error returned is of type [*ParseError].
+func ForNodes(line string, reverse bool) iter.Seq2[Node, error] {
+	return nodes(line, reverse, func(e *Element) Node { return e.For })
+}
+
+// ByNodes is like [ForNodes], but yields the by nodes.
+// Elements without a by node are skipped.
+// The error returned is of type [*ParseError].
+func ByNodes(line string, reverse bool) iter.Seq2[Node, error] {
+	return nodes(line, reverse, func(e *Element) Node { return e.By })
+}
+
+// nodes yields the non-empty node returned by node for each
+// element in the given line.
+func nodes(line string, reverse bool, node func(*Element) Node) iter.Seq2[Node, error] {
+	return func(yield func(Node, error) bool) {
+		for elem, err := range ParseShared(line, reverse) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			if n := node(elem); n != "" && !yield(n, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ParseAll parses all elements in the given line and returns
+// them as a slice. If reverse is true, the elements are parsed
+// in reverse. On error no elements are returned.
+// The error returned is of type [*ParseError].
+func ParseAll(line string, reverse bool) ([]*Element, error) {
+	var elems []*Element
+	for elem, err := range Parse(line, reverse) {
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// Ends returns the first and last element in the given line
+// in a single pass. If the line contains one element, first
+// and last are the same element. If the line is empty, both
+// are nil.
+// The error returned is of type [*ParseError].
+func Ends(line string) (first, last *Element, err error) {
+	if trimOWS(line) == "" {
+		return nil, nil, nil
+	}
+	for elem, err := range Parse(line, false) {
+		if err != nil {
+			return nil, nil, err
+		}
+		if first == nil {
+			first = elem
+		}
+		last = elem
+	}
+	return first, last, nil
+}
+
 // Element contains information about a proxy.
 type Element struct {
 	By    Node
 	For   Node
 	Proto string
 	Host  string
-	Extra []Paramater
+	Extra []Parameter
 }
 
-// Pair represents a key-value pair making up a element
+// Parameter represents a key-value pair making up an element
 // parameter.
-type Paramater struct {
+type Parameter struct {
 	Key, Value string
 }
 
+// Paramater is the misspelled former name of [Parameter].
+//
+// Deprecated: Use [Parameter] instead.
+type Paramater = Parameter
+
 // String returns the string equivalent of element e.
 // It assumes that element e is valid.
 func (e Element) String() string {
-	var pairs []string
+	return string(e.AppendTo(make([]byte, 0, e.Size())))
+}
+
+// AppendTo appends the string form of element e, as returned by
+// [Element.String], to dst and returns the extended buffer. A
+// buffer can be reused to serialize many elements.
+func (e *Element) AppendTo(dst []byte) []byte {
+	start := len(dst)
+	add := func(key, value string) {
+		if len(dst) > start {
+			dst = append(dst, ';')
+		}
+		dst = append(dst, key...)
+		dst = append(dst, '=')
+		dst = AppendEscape(dst, value)
+	}
+	if e.By != "" {
+		add("by", string(e.By))
+	}
+	if e.For != "" {
+		add("for", string(e.For))
+	}
+	if e.Proto != "" {
+		add("proto", e.Proto)
+	}
+	if e.Host != "" {
+		add("host", e.Host)
+	}
+	for _, p := range e.Extra {
+		add(p.Key, p.Value)
+	}
+	return dst
+}
+
+// StringSorted is like [Element.String], but the extra parameters
+// are sorted by key, which gives the same string regardless of the
+// order in which they are added. Parameters with the same key keep
+// their order.
+func (e Element) StringSorted() string {
+	e.Extra = slices.Clone(e.Extra)
+	slices.SortStableFunc(e.Extra, func(a, b Parameter) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return e.String()
+}
+
+// MarshalText implements [encoding.TextMarshaler] using
+// [Element.String].
+func (e Element) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler]. The text
+// must contain a single element, empty text results in an empty
+// element. Like [Parse], empty elements caused by stray commas
+// are skipped. Element e is only modified if the text parses.
+// The error returned is of type [*ParseError].
+func (e *Element) UnmarshalText(text []byte) error {
+	s := string(text)
+	var part string
+	start, pos := 0, 0
+	for p := range splitUnquotedSeq(s, ',') {
+		if trimOWS(p) != "" {
+			if part != "" {
+				return &ParseError{Msg: `multiple elements in`, Text: s, Offset: pos - 1, err: ErrTooManyElements}
+			}
+			part, start = p, pos
+		}
+		pos += len(p) + 1
+	}
+	var elem Element
+	if part != "" {
+		if err := parseElement(&elem, part, nil); err != nil {
+			return errorAt(err, start)
+		}
+	}
+	*e = elem
+	return nil
+}
+
+// Equal returns true if elements e and other have equal fields
+// and equal extra parameters in the same order. As they are
+// tokens, the proto and the extra parameter keys are compared
+// case-insensitively. Other values are compared byte-wise; use
+// [Chain.Canonicalize] to compare elements semantically. Two
+// nil elements are equal.
+func (e *Element) Equal(other *Element) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+	return e.By == other.By &&
+		e.For == other.For &&
+		strings.EqualFold(e.Proto, other.Proto) &&
+		e.Host == other.Host &&
+		slices.EqualFunc(e.Extra, other.Extra, func(a, b Parameter) bool {
+			return strings.EqualFold(a.Key, b.Key) && a.Value == b.Value
+		})
+}
+
+// Clone returns a deep copy of element e that does not share
+// its extra parameters with e. It returns nil if e is nil.
+func (e *Element) Clone() *Element {
+	if e == nil {
+		return nil
+	}
+	c := *e
+	c.Extra = slices.Clone(e.Extra)
+	return &c
+}
+
+// Validate checks whether element e can be serialized into a
+// valid element by [Element.String]. It checks that the by and
+// for nodes are IP addresses, "unknown" or obfuscated identifiers
+// with an optional port, that proto and the extra parameter names
+// are tokens, that host is a valid Host header value and that the
+// extra parameter values contain no control characters other than
+// horizontal tab. Empty well-known parameters are not checked, as
+// they are omitted.
+// The error returned is of type [*ParseError], with an
+// offset of -1.
+func (e *Element) Validate() error {
+	if e.By != "" && !validNode(e.By) {
+		return &ParseError{Msg: `invalid by node`, Text: string(e.By), Offset: -1, err: ErrInvalidValue}
+	}
+	if e.For != "" && !validNode(e.For) {
+		return &ParseError{Msg: `invalid for node`, Text: string(e.For), Offset: -1, err: ErrInvalidValue}
+	}
+	if e.Proto != "" && !validElementToken(e.Proto) {
+		return &ParseError{Msg: `invalid proto`, Text: e.Proto, Offset: -1, err: ErrInvalidValue}
+	}
+	if e.Host != "" && !validHost(e.Host) {
+		return &ParseError{Msg: `invalid host`, Text: e.Host, Offset: -1, err: ErrInvalidValue}
+	}
+	for _, p := range e.Extra {
+		if !validElementToken(p.Key) {
+			return &ParseError{Msg: `invalid token`, Text: p.Key, Offset: -1, err: ErrInvalidToken}
+		}
+		if !validValue(p.Value) {
+			return &ParseError{Msg: `invalid value`, Text: p.Value, Offset: -1, err: ErrInvalidValue}
+		}
+	}
+	return nil
+}
+
+// Size returns the length of the string returned by
+// [Element.String] without building it.
+func (e Element) Size() int {
+	n, pairs := 0, 0
+	add := func(key, value string) {
+		n += len(key) + 1 + escapedLen(value)
+		pairs++
+	}
 	if e.By != "" {
-		pairs = append(pairs, "by="+escape(string(e.By)))
+		add("by", string(e.By))
 	}
 	if e.For != "" {
-		pairs = append(pairs, "for="+escape(string(e.For)))
+		add("for", string(e.For))
 	}
 	if e.Proto != "" {
-		pairs = append(pairs, "proto="+escape(e.Proto))
+		add("proto", e.Proto)
 	}
 	if e.Host != "" {
-		pairs = append(pairs, "host="+escape(e.Host))
+		add("host", e.Host)
 	}
 	for _, p := range e.Extra {
-		pairs = append(pairs, p.Key+"="+escape(p.Value))
+		add(p.Key, p.Value)
+	}
+	if pairs > 1 {
+		n += pairs - 1
+	}
+	return n
+}
+
+// Params returns all parameters of element e keyed by their
+// lowercase name. The well-known parameters are stored under
+// "by", "for", "proto" and "host" when not empty, followed by
+// the extra parameters. Later parameters overwrite earlier
+// ones with the same name.
+func (e Element) Params() map[string]string {
+	m := make(map[string]string, 4+len(e.Extra))
+	if e.By != "" {
+		m["by"] = string(e.By)
+	}
+	if e.For != "" {
+		m["for"] = string(e.For)
+	}
+	if e.Proto != "" {
+		m["proto"] = e.Proto
+	}
+	if e.Host != "" {
+		m["host"] = e.Host
+	}
+	for _, p := range e.Extra {
+		m[strings.ToLower(p.Key)] = p.Value
+	}
+	return m
+}
+
+// Get returns the value of parameter key in element e. The key
+// is matched case-insensitively against "by", "for", "proto" and
+// "host" and then against the extra parameters, of which the
+// first match is returned. It returns false if the parameter is
+// not present or an empty well-known parameter.
+func (e *Element) Get(key string) (string, bool) {
+	switch strings.ToLower(key) {
+	case "by":
+		return string(e.By), e.By != ""
+	case "for":
+		return string(e.For), e.For != ""
+	case "proto":
+		return e.Proto, e.Proto != ""
+	case "host":
+		return e.Host, e.Host != ""
+	}
+	for _, p := range e.Extra {
+		if strings.EqualFold(p.Key, key) {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets parameter key of element e to value. The key is
+// matched case-insensitively against "by", "for", "proto" and
+// "host", which are set in their field. Other parameters replace
+// the extra parameters with the same key or are appended, so the
+// key occurs only once.
+func (e *Element) Set(key, value string) {
+	switch strings.ToLower(key) {
+	case "by":
+		e.By = Node(value)
+		return
+	case "for":
+		e.For = Node(value)
+		return
+	case "proto":
+		e.Proto = value
+		return
+	case "host":
+		e.Host = value
+		return
 	}
-	return strings.Join(pairs, ";")
+	i := slices.IndexFunc(e.Extra, func(p Parameter) bool {
+		return strings.EqualFold(p.Key, key)
+	})
+	if i == -1 {
+		e.Extra = append(e.Extra, Parameter{key, value})
+		return
+	}
+	e.Extra[i] = Parameter{key, value}
+	rest := slices.DeleteFunc(e.Extra[i+1:], func(p Parameter) bool {
+		return strings.EqualFold(p.Key, key)
+	})
+	e.Extra = e.Extra[:i+1+len(rest)]
+}
+
+// HostDecoded returns the host of element e with percent-encoded
+// characters decoded, like [url.PathUnescape]. RFC 7239 does not
+// use percent-encoding for the host parameter, this only guards
+// against misbehaving clients. The Host field is not modified.
+func (e Element) HostDecoded() (string, error) {
+	return url.PathUnescape(e.Host)
+}
+
+// HostPort splits the host of element e into host name and port,
+// like "example.com:8443" into "example.com" and "8443". Square
+// brackets around an IPv6 address are removed from the host
+// name, like [Node.AddrPort] does. If the host has no port, the
+// port is empty. It returns false if the host is empty, an IPv6
+// address is not enclosed in brackets or a colon is not followed
+// by a numeric port.
+func (e Element) HostPort() (host, port string, ok bool) {
+	host = e.Host
+	hasPort := false
+	if strings.HasPrefix(host, "[") {
+		end := strings.IndexByte(host, ']')
+		if end == -1 {
+			return "", "", false
+		}
+		host, port = host[1:end], host[end+1:]
+		if port != "" {
+			if port[0] != ':' {
+				return "", "", false
+			}
+			port, hasPort = port[1:], true
+		}
+	} else if i := strings.IndexByte(host, ':'); i != -1 {
+		host, port, hasPort = host[:i], host[i+1:], true
+	}
+	if host == "" || hasPort && !isDigits(port) {
+		return "", "", false
+	}
+	return host, port, true
+}
+
+// ProtoAllowed returns true if the proto of element e
+// case-insensitively equals one of the allowed schemes.
+// It returns false if the proto is empty.
+func (e Element) ProtoAllowed(allowed ...string) bool {
+	if e.Proto == "" {
+		return false
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(e.Proto, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProtoIsSecure returns true if the proto of element e
+// case-insensitively equals "https".
+func (e Element) ProtoIsSecure() bool {
+	return strings.EqualFold(e.Proto, "https")
 }
 
 // A Node identifier is one of the following:
@@ -163,6 +772,66 @@ type Node string
 // AddrPort attempts to parse node n as a IP address and port.
 // Either addr or node port returned may be invalid.
 func (n Node) AddrPort() (netip.Addr, NodePort, bool) {
+	host, np := n.split()
+	a, err := netip.ParseAddr(host)
+	return a, np, err == nil || np.IsValid()
+}
+
+// Addr returns the IP address of node n. It returns false if
+// node n is not an IP address, with or without port.
+func (n Node) Addr() (netip.Addr, bool) {
+	host, _ := n.split()
+	a, err := netip.ParseAddr(host)
+	return a, err == nil
+}
+
+// Port returns the port of node n, which is numeric or obfuscated.
+// It returns false if node n has no port.
+func (n Node) Port() (NodePort, bool) {
+	_, np := n.split()
+	return np, np.IsValid()
+}
+
+// NetipAddrPort returns node n as an address and port. It returns
+// false if node n is not an IP address or has no numeric port,
+// for example when the port is obfuscated.
+func (n Node) NetipAddrPort() (netip.AddrPort, bool) {
+	host, np := n.split()
+	a, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	p, ok := np.Uint16()
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(a, p), true
+}
+
+// IsIP returns true if node n is an IP address, with or
+// without port. Unlike [Node.IsPlainIP], the form of the
+// address is not checked.
+func (n Node) IsIP() bool {
+	_, ok := n.Addr()
+	return ok
+}
+
+// IsPlainIP returns true if node n is an IP address without
+// port. As required by RFC 7239, section 6, an IPv6 address
+// must be enclosed in square brackets, like "[2001:db8::1]".
+func (n Node) IsPlainIP() bool {
+	s := string(n)
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		a, err := netip.ParseAddr(s[1 : len(s)-1])
+		return err == nil && a.Is6()
+	}
+	a, err := netip.ParseAddr(s)
+	return err == nil && a.Is4()
+}
+
+// split splits node n into node name and port. Brackets
+// around an IPv6 address are removed from the name.
+func (n Node) split() (string, NodePort) {
 	host, port, err := net.SplitHostPort(string(n))
 	if err != nil {
 		host = string(n)
@@ -170,16 +839,118 @@ func (n Node) AddrPort() (netip.Addr, NodePort, bool) {
 			host = host[1 : len(host)-1]
 		}
 	}
-	a, err := netip.ParseAddr(host)
-	np := NodePort(port)
-	return a, np, err == nil || np.IsValid()
+	return host, NodePort(port)
+}
+
+// WithPort returns node n with its port replaced by port p, or
+// removed if p is empty. An IPv6 address is enclosed in square
+// brackets. It returns false if node n is empty or port p is
+// neither a numeric port without leading zeros nor a valid
+// obfuscated port.
+func (n Node) WithPort(p NodePort) (Node, bool) {
+	name, _ := n.split()
+	if name == "" {
+		return "", false
+	}
+	if strings.Contains(name, ":") {
+		name = "[" + name + "]"
+	}
+	switch {
+	case p == "":
+		return Node(name), true
+	case validPort(string(p)) && (len(p) == 1 || p[0] != '0'),
+		p.IsValidObfuscated():
+		return Node(name + ":" + string(p)), true
+	}
+	return "", false
+}
+
+// NodeFromNetAddr returns the node for address a. It supports
+// [*net.TCPAddr], [*net.UDPAddr] and addresses of which the
+// string form is an IP address and port. If the port is zero,
+// the node contains only the IP address.
+func NodeFromNetAddr(a net.Addr) (Node, bool) {
+	var ap netip.AddrPort
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		if a == nil {
+			return "", false
+		}
+		ap = a.AddrPort()
+	case *net.UDPAddr:
+		if a == nil {
+			return "", false
+		}
+		ap = a.AddrPort()
+	case nil:
+		return "", false
+	default:
+		var err error
+		ap, err = netip.ParseAddrPort(a.String())
+		if err != nil {
+			return "", false
+		}
+	}
+	if !ap.Addr().IsValid() {
+		return "", false
+	}
+	return NodeFromAddrPort(ap), true
+}
+
+// NodeFromAddrPort returns address and port ap as node, like
+// "192.0.2.43:4711" or "[2001:db8:cafe::17]:4711". If the port
+// is zero, the node contains only the address, which is still
+// bracketed if it is an IPv6 address. IPv4-mapped IPv6 addresses
+// are formatted as IPv4 address. If the address is invalid, the
+// node is empty.
+func NodeFromAddrPort(ap netip.AddrPort) Node {
+	addr := ap.Addr().Unmap()
+	if !addr.IsValid() {
+		return ""
+	}
+	if ap.Port() != 0 {
+		return Node(netip.AddrPortFrom(addr, ap.Port()).String())
+	}
+	if addr.Is6() {
+		return Node("[" + addr.String() + "]")
+	}
+	return Node(addr.String())
+}
+
+// NodeFromAddr returns address a as node, like [NodeFromAddrPort]
+// with a zero port.
+func NodeFromAddr(a netip.Addr) Node {
+	return NodeFromAddrPort(netip.AddrPortFrom(a, 0))
 }
 
 // IsObfuscated returns true if node n is a generated token.
+// Only the leading underscore is checked; use
+// [Node.IsValidObfuscated] to check the other characters too.
 func (n Node) IsObfuscated() bool {
 	return strings.HasPrefix(string(n), "_")
 }
 
+// IsValidObfuscated returns true if the name of node n is
+// obfuscated and only contains characters allowed by RFC 7239,
+// section 6.3. Unlike [Node.IsObfuscated] it rejects nodes like
+// "_ga zonk". The port, if any, is not checked.
+func (n Node) IsValidObfuscated() bool {
+	name, _ := n.split()
+	return validObfuscated(name)
+}
+
+// IsAmbiguous returns true if node n is obfuscated, but the part
+// after the underscore is an IP address, like "_192.0.2.1". Per
+// RFC 7239 such a node is an obfuscated identifier, but it might
+// be misinterpreted as an address.
+func (n Node) IsAmbiguous() bool {
+	if !n.IsObfuscated() {
+		return false
+	}
+	addr, _, _ := n[1:].AddrPort()
+	return addr.IsValid()
+}
+
 // IsUnknown returns true if node n is the unknown token.
 func (n Node) IsUnknown() bool {
 	return n == "unknown"
@@ -189,6 +960,11 @@ func (n Node) IsUnknown() bool {
 // or obfuscated.
 type NodePort string
 
+// NodePortFromUint16 returns numeric port p as node port.
+func NodePortFromUint16(p uint16) NodePort {
+	return NodePort(strconv.FormatUint(uint64(p), 10))
+}
+
 // IsValid returns true if node port np is not empty.
 func (np NodePort) IsValid() bool {
 	return np != ""
@@ -200,24 +976,179 @@ func (np NodePort) Uint16() (uint16, bool) {
 	return uint16(u), err == nil
 }
 
+// Equal returns true if node ports np and other are equal. Two
+// numeric ports are compared by value, so "443" equals "0443".
+// Otherwise the ports are compared byte-wise.
+func (np NodePort) Equal(other NodePort) bool {
+	a, aok := np.Uint16()
+	b, bok := other.Uint16()
+	if aok && bok {
+		return a == b
+	}
+	return np == other
+}
+
 // IsObfuscated returns true if node port np is obfuscated.
 func (np NodePort) IsObfuscated() bool {
 	return strings.HasPrefix(string(np), "_")
 }
 
+// IsValidObfuscated returns true if node port np is obfuscated
+// and only contains characters allowed by RFC 7239, section 6.3.
+// Unlike [NodePort.IsObfuscated] it rejects ports like "_ga zonk".
+func (np NodePort) IsValidObfuscated() bool {
+	return validObfuscated(string(np))
+}
+
+// validObfuscated reports whether s is a valid obfuscated
+// identifier per RFC 7239, section 6.3:
+//
+//	obfnode = "_" 1*( ALPHA / DIGIT / "." / "_" / "-")
+//	obfport = "_" 1*(ALPHA / DIGIT / "." / "_" / "-")
+func validObfuscated(s string) bool {
+	if len(s) < 2 || s[0] != '_' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 const header = "Forwarded"
 
-// ParseRequests parses elements in the Forwarded header
-// in request r. If reverse is true, the elements are
-// parsed in reverse.
+// ParseRequest parses elements in the Forwarded header in
+// request r. As required by RFC 7230, section 3.2.2, multiple
+// Forwarded header fields are parsed as if they were a single
+// comma-separated line, as some proxies add a header field
+// instead of appending to the existing one. If reverse is
+// true, the elements are parsed in reverse.
 // The error returned is of type [*ParseError].
 func ParseRequest(r *http.Request, reverse bool) iter.Seq2[*Element, error] {
-	return Parse(r.Header.Get(header), reverse)
+	return Parse(headerLine(r.Header, header), reverse)
 }
 
 // LastRequest returns the last element in the Forwarded
-// header in request r.
+// header fields in request r.
 // The error returned is of type [*ParseError].
 func LastRequest(r *http.Request) (*Element, error) {
-	return Last(r.Header.Get(header))
+	return Last(headerLine(r.Header, header))
+}
+
+// FirstRequest returns the first element in the Forwarded
+// header fields in request r. If the header is empty, the
+// element is nil.
+// The error returned is of type [*ParseError].
+func FirstRequest(r *http.Request) (*Element, error) {
+	return First(headerLine(r.Header, header))
+}
+
+// ParseAllRequest parses all elements in all Forwarded header
+// fields in request r and returns them as a slice, like
+// [ParseAll]. If reverse is true, the elements are parsed in
+// reverse. On error no elements are returned.
+// The error returned is of type [*ParseError].
+func ParseAllRequest(r *http.Request, reverse bool) ([]*Element, error) {
+	return ParseAll(headerLine(r.Header, header), reverse)
+}
+
+// ParseNamedRequest parses elements in all header fields with the
+// given name in request r, as if they were a single comma-separated
+// line. This allows headers other than Forwarded that use the same
+// syntax. If reverse is true, the elements are parsed in reverse.
+// The error returned is of type [*ParseError].
+func ParseNamedRequest(r *http.Request, name string, reverse bool) iter.Seq2[*Element, error] {
+	return Parse(headerLine(r.Header, name), reverse)
+}
+
+// AppendRequest appends the elements to the last Forwarded header
+// field in request r, leaving earlier header fields untouched. If
+// r has no Forwarded header field, it is added. Empty elements are
+// skipped.
+func AppendRequest(r *http.Request, elems ...*Element) {
+	var b Builder
+	for _, e := range elems {
+		b.Append(e)
+	}
+	if b.String() == "" {
+		return
+	}
+
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	values := r.Header[header]
+	if len(values) == 0 {
+		r.Header.Set(header, b.String())
+		return
+	}
+	last := &values[len(values)-1]
+	*last = b.AppendTo(*last)
+}
+
+// SetRequest appends element e to the Forwarded header in
+// request r. All Forwarded header fields are merged into a single
+// field, as if they were a single comma-separated line. If r has
+// no Forwarded header field, it is added. If element e is nil or
+// empty, r is not modified.
+func SetRequest(r *http.Request, e *Element) {
+	b := NewBuilder().Append(e)
+	if b.String() == "" {
+		return
+	}
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Header.Set(header, b.AppendTo(headerLine(r.Header, header)))
+}
+
+// RequestHasForwarded returns true if request r has a Forwarded
+// header field, even if its value is empty.
+func RequestHasForwarded(r *http.Request) bool {
+	_, ok := r.Header[header]
+	return ok
+}
+
+// ParseResponse parses elements in the Forwarded header
+// fields in response resp. Multiple header fields are parsed
+// as a single comma-separated line. If reverse is true, the
+// elements are parsed in reverse.
+// The error returned is of type [*ParseError].
+func ParseResponse(resp *http.Response, reverse bool) iter.Seq2[*Element, error] {
+	return Parse(headerLine(resp.Header, header), reverse)
+}
+
+// headerLine returns the header fields with the given name
+// in header h combined into a single line. Empty header fields
+// are skipped, so they do not introduce empty elements.
+func headerLine(h http.Header, name string) string {
+	var b strings.Builder
+	for _, v := range h.Values(name) {
+		v = trimOWS(v)
+		if v == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// metadataKey is the Forwarded header as gRPC metadata key.
+const metadataKey = "forwarded"
+
+// ParseMetadata parses elements in the forwarded values of
+// gRPC metadata md. Multiple values are parsed as a single
+// comma-separated line. If reverse is true, the elements
+// are parsed in reverse.
+// The error returned is of type [*ParseError].
+func ParseMetadata(md map[string][]string, reverse bool) iter.Seq2[*Element, error] {
+	return Parse(strings.Join(md[metadataKey], ", "), reverse)
 }
@@ -1,10 +1,14 @@
 package forwarded
 
 import (
+	"errors"
 	"iter"
+	"net"
+	"net/http"
 	"net/netip"
 	"reflect"
 	"slices"
+	"strings"
 	"testing"
 )
 
@@ -87,12 +91,80 @@ var parseTests = []parseTest{
 			},
 		},
 	},
-
+	{
+		name: "quoted/semicolon/for",
+		in:   `for="a;b";proto=http`,
+		want: []*Element{
+			{For: "a;b", Proto: "http"},
+		},
+	},
+	{
+		name: "quoted/semicolon/host",
+		in:   `for=192.0.2.43;host="a;b", for=unknown`,
+		want: []*Element{
+			{For: "192.0.2.43", Host: "a;b"},
+			{For: "unknown"},
+		},
+	},
+	{
+		name: "quoted/semicolon/extra",
+		in:   `key=";";token="\";\"";for=_gazonk`,
+		want: []*Element{{
+			For: "_gazonk",
+			Extra: []Parameter{
+				{"key", ";"},
+				{"token", `";"`},
+			},
+		}},
+	},
+	{
+		name: "quoted/semicolon/escaped",
+		in:   `key="a\";";proto=https;token="\\";for=_x`,
+		want: []*Element{{
+			For:   "_x",
+			Proto: "https",
+			Extra: []Parameter{
+				{"key", `a";`},
+				{"token", `\`},
+			},
+		}},
+	},
+	{
+		name: "quoted/semicolon/space",
+		in:   `for=192.0.2.43 ; host="a;b" ;proto=http`,
+		want: []*Element{
+			{For: "192.0.2.43", Host: "a;b", Proto: "http"},
+		},
+	},
+	{
+		name: "quoted/comma/host",
+		in:   `for=192.0.2.43;host="a,b"`,
+		want: []*Element{
+			{For: "192.0.2.43", Host: "a,b"},
+		},
+	},
+	{
+		name: "quoted/comma/multiple",
+		in:   `for=_a;key="1,2", for=_b;key="3,4" ,for=_c`,
+		want: []*Element{
+			{For: "_a", Extra: []Parameter{{"key", "1,2"}}},
+			{For: "_b", Extra: []Parameter{{"key", "3,4"}}},
+			{For: "_c"},
+		},
+	},
+	{
+		name: "quoted/comma/escaped",
+		in:   `for=192.0.2.43;key="a\",b\\", for=unknown;token="\","`,
+		want: []*Element{
+			{For: "192.0.2.43", Extra: []Parameter{{"key", `a",b\`}}},
+			{For: "unknown", Extra: []Parameter{{"token", `",`}}},
+		},
+	},
 	{
 		name: "rfc7239/5.5",
 		in:   `key=value;token="\"quoted-string\""`,
 		want: []*Element{{
-			Extra: []Paramater{
+			Extra: []Parameter{
 				{"key", "value"},
 				{"token", `"quoted-string"`},
 			},
@@ -109,26 +181,988 @@ func TestParse(t *testing.T) {
 	}
 }
 
-func testParse(c parseTest, reverse bool) func(t *testing.T) {
-	return func(t *testing.T) {
-		var got []*Element
-		for elem, err := range Parse(c.in, reverse) {
-			if err != nil {
-				t.Fatalf("got error: %v\nelems: %v", err, got)
+func testParse(c parseTest, reverse bool) func(t *testing.T) {
+	return func(t *testing.T) {
+		var got []*Element
+		for elem, err := range Parse(c.in, reverse) {
+			if err != nil {
+				t.Fatalf("got error: %v\nelems: %v", err, got)
+			}
+			got = append(got, elem)
+		}
+
+		want := c.want
+		if reverse {
+			want = slices.Clone(want)
+			slices.Reverse(want)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("\ngot:  %v\nwant: %v", got, want)
+		}
+	}
+}
+
+type parseErrorTest struct {
+	name string
+	in   string
+	msg  string
+}
+
+var parseErrorTests = []parseErrorTest{
+	{
+		name: "unterminated",
+		in:   `for="unterminated`,
+		msg:  "unterminated quoted-string",
+	},
+	{
+		name: "unterminated/last",
+		in:   `for=192.0.2.43, for="unterminated`,
+		msg:  "unterminated quoted-string",
+	},
+	{
+		name: "unterminated/escaped",
+		in:   `for="unterminated\"`,
+		msg:  "unterminated quoted-string",
+	},
+}
+
+func TestParseEmptyElements(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []*Element
+	}{
+		{"for=192.0.2.43,, for=198.51.100.17", []*Element{{For: "192.0.2.43"}, {For: "198.51.100.17"}}},
+		{"for=192.0.2.43,", []*Element{{For: "192.0.2.43"}}},
+		{" , \t,for=192.0.2.43 , ", []*Element{{For: "192.0.2.43"}}},
+		{",", nil},
+	}
+
+	for _, c := range cases {
+		for _, reverse := range []bool{false, true} {
+			got, err := ParseAll(c.in, reverse)
+			if err != nil {
+				t.Errorf("ParseAll(%q, %v) error: %v", c.in, reverse, err)
+				continue
+			}
+			if reverse {
+				slices.Reverse(got)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("ParseAll(%q, %v):\ngot:  %v\nwant: %v", c.in, reverse, got, c.want)
+			}
+		}
+
+		var got []*Element
+		for elem, err := range ParseReader(strings.NewReader(c.in), false) {
+			if err != nil {
+				t.Fatalf("ParseReader(%q) error: %v", c.in, err)
+			}
+			got = append(got, elem)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseReader(%q):\ngot:  %v\nwant: %v", c.in, got, c.want)
+		}
+
+		n := 0
+		for _, err := range ParseView(c.in) {
+			if err != nil {
+				t.Fatalf("ParseView(%q) error: %v", c.in, err)
+			}
+			n++
+		}
+		if n != len(c.want) || Count(c.in) != len(c.want) {
+			t.Errorf("ParseView(%q) and Count = %d, %d elements, want: %d", c.in, n, Count(c.in), len(c.want))
+		}
+
+		var err error
+		for _, err = range ParseStrict(c.in, false) {
+			if err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, ErrNoEquals) {
+			t.Errorf("ParseStrict(%q) error = %v, want: %v", c.in, err, ErrNoEquals)
+		}
+	}
+}
+
+func TestParseOWSEquals(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []*Element
+	}{
+		{`for = 192.0.2.43`, []*Element{{For: "192.0.2.43"}}},
+		{`for =192.0.2.43`, []*Element{{For: "192.0.2.43"}}},
+		{`for= 192.0.2.43`, []*Element{{For: "192.0.2.43"}}},
+		{
+			"for=192.0.2.43;proto =https, for=unknown ;key=\t\"a b\" ",
+			[]*Element{
+				{For: "192.0.2.43", Proto: "https"},
+				{For: "unknown", Extra: []Parameter{{"key", "a b"}}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		got, err := ParseAll(c.in, false)
+		if err != nil {
+			t.Errorf("ParseAll(%s) error: %v", c.in, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseAll(%s):\ngot:  %v\nwant: %v", c.in, got, c.want)
+		}
+
+		i := 0
+		for v, err := range ParseView(c.in) {
+			if err != nil {
+				t.Errorf("ParseView(%s) error: %v", c.in, err)
+				break
+			}
+			if got := v.For(); i < len(c.want) && got != string(c.want[i].For) {
+				t.Errorf("ParseView(%s) for = %q, want: %q", c.in, got, c.want[i].For)
+			}
+			i++
+		}
+
+		for _, err = range ParseStrict(c.in, false) {
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			t.Errorf("ParseStrict(%s) got no error", c.in)
+		}
+	}
+}
+
+func TestParseProtoSlash(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []*Element
+	}{
+		{`for=192.0.2.43;proto=h2`, []*Element{
+			{For: "192.0.2.43", Proto: "h2"},
+		}},
+		{`for=192.0.2.43;proto="http/1.1"`, []*Element{
+			{For: "192.0.2.43", Proto: "http/1.1"},
+		}},
+		{`for=192.0.2.43;proto=http/1.1, proto=HTTP/2`, []*Element{
+			{For: "192.0.2.43", Proto: "http/1.1"},
+			{Proto: "HTTP/2"},
+		}},
+	}
+	for _, c := range cases {
+		for _, reverse := range []bool{false, true} {
+			var got []*Element
+			for e, err := range Parse(c.in, reverse) {
+				if err != nil {
+					t.Fatalf("Parse(%s, %t) error: %v", c.in, reverse, err)
+				}
+				got = append(got, e)
+			}
+			if reverse {
+				slices.Reverse(got)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Parse(%s, %t) = %v, want %v", c.in, reverse, got, c.want)
+			}
+		}
+	}
+
+	for _, in := range []string{`for=192.0.2.43/24`, `host=example.com/a`} {
+		for _, err := range Parse(in, false) {
+			if pe, ok := err.(*ParseError); !ok || pe.Msg != "invalid value" {
+				t.Errorf("Parse(%s) error = %v, want %q", in, err, "invalid value")
+			}
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, c := range parseErrorTests {
+		t.Run(c.name, func(t *testing.T) {
+			t.Run("forward", testParseError(c, false))
+			t.Run("reverse", testParseError(c, true))
+		})
+	}
+}
+
+func testParseError(c parseErrorTest, reverse bool) func(t *testing.T) {
+	return func(t *testing.T) {
+		for _, err := range Parse(c.in, reverse) {
+			if err == nil {
+				continue
+			}
+			pe, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("got error of type %T, want *ParseError", err)
+			}
+			if pe.Msg != c.msg {
+				t.Errorf("got error %q, want message %q", err, c.msg)
+			}
+			return
+		}
+		t.Errorf("got no error, want %q", c.msg)
+	}
+}
+
+func TestParseErrorIs(t *testing.T) {
+	cases := []struct {
+		in   string
+		want error
+	}{
+		{"for", ErrNoEquals},
+		{"f(r=192.0.2.43", ErrInvalidToken},
+		{`for="192.0.2.43\`, ErrUnterminatedQuote},
+		{`for=192.0.2.43 ";"`, ErrInvalidValue},
+	}
+
+	for _, c := range cases {
+		for _, err := range Parse(c.in, false) {
+			if !errors.Is(err, c.want) {
+				t.Errorf("Parse(%s) error = %v, want errors.Is %v", c.in, err, c.want)
+			}
+		}
+	}
+
+	p := &Parser{MaxElements: 1, Strict: true}
+	for _, c := range []struct {
+		in   string
+		want error
+	}{
+		{"for=192.0.2.43, for=198.51.100.17", ErrTooManyElements},
+		{"for=192.0.2.43;for=198.51.100.17", ErrDuplicateParameter},
+	} {
+		var err error
+		for _, err = range p.Parse(c.in, false) {
+			if err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, c.want) {
+			t.Errorf("Parser.Parse(%s) error = %v, want errors.Is %v", c.in, err, c.want)
+		}
+	}
+
+	for _, in := range []string{
+		`for="_ga zonk"`,
+		`for="192.0.2.43:_ga zonk"`,
+		`for="192.0.2.43:65536"`,
+		`for="192.0.2.43:0443"`,
+		`for=example.com`,
+		`proto=1http`,
+		`host="exa mple.com"`,
+		"for=\"\xc3\xa9\"",
+	} {
+		var err error
+		for _, err = range ParseStrict(in, false) {
+			if err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("ParseStrict(%s) error = %v, want errors.Is %v", in, err, ErrInvalidValue)
+		}
+	}
+
+	if err := (&ParseError{Msg: `invalid token`, Text: "a b"}); err.Unwrap() != nil {
+		t.Errorf("Unwrap() of error without sentinel = %v, want nil", err.Unwrap())
+	}
+	if err := (&Element{For: "a b"}).Validate(); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Validate() error = %v, want errors.Is %v", err, ErrInvalidValue)
+	}
+	if got, want := (&ParseError{Msg: `invalid token`, Text: "a b", Offset: 42}).Error(), `forwarded: invalid token "a b" at offset 42`; got != want {
+		t.Errorf("Error() = %s, want: %s", got, want)
+	}
+}
+
+func TestParseErrorOffset(t *testing.T) {
+	cases := []struct {
+		in     string
+		msg    string
+		offset int
+	}{
+		{"for", `no "=" found in`, 0},
+		{"for=192.0.2.43, by", `no "=" found in`, 16},
+		{"for=192.0.2.43;  f(r=a", `invalid token`, 17},
+		{`for=192.0.2.43, for=unknown;host="a`, `unterminated quoted-string`, 33},
+		{`for=192.0.2.43, for=unknown;host=a"b`, `invalid value`, 33},
+		{`for=_a, for=_b;by="_c";key=a b, for=_d`, `invalid value`, 27},
+	}
+
+	for _, c := range cases {
+		for _, reverse := range []bool{false, true} {
+			var err error
+			for _, err = range Parse(c.in, reverse) {
+				if err != nil {
+					break
+				}
+			}
+			pe, ok := err.(*ParseError)
+			if !ok || pe.Msg != c.msg || pe.Offset != c.offset {
+				t.Errorf("Parse(%s, %v) error = %v, want %q at offset %d", c.in, reverse, err, c.msg, c.offset)
+				continue
+			}
+			if !strings.HasPrefix(c.in[pe.Offset:], pe.Text) {
+				t.Errorf("Parse(%s, %v) error text %q not at offset %d", c.in, reverse, pe.Text, pe.Offset)
+			}
+		}
+	}
+
+	p := &Parser{Strict: true, Lenient: true, MaxElements: 2}
+	for _, c := range []struct {
+		in     string
+		msg    string
+		offset int
+	}{
+		{`for=_a, ,for = "_b:0443"`, "leading zero in port", 15},
+		{"for=_a;;for=_b", "duplicate parameter", 8},
+		{"for=_a, for=_b,  for=_c", "too many elements", 17},
+	} {
+		var err error
+		for _, err = range p.Parse(c.in, false) {
+			if err != nil {
+				break
+			}
+		}
+		if pe, ok := err.(*ParseError); !ok || pe.Msg != c.msg || pe.Offset != c.offset {
+			t.Errorf("Parser.Parse(%s) error = %v, want %q at offset %d", c.in, err, c.msg, c.offset)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{" \t", 0},
+		{"for=192.0.2.43", 1},
+		{"for=192.0.2.43, for=198.51.100.17;by=_a", 2},
+		{`for=192.0.2.43;host="a,b", for=unknown`, 2},
+		{`key="\",\"", for=unknown`, 2},
+		{"for=192.0.2.43,", 1},
+		{"for=192.0.2.43,, for=198.51.100.17", 2},
+		{",,", 0},
+		{`for=192.0.2.43, host="a,b`, 2},
+	}
+
+	for _, c := range cases {
+		if got := Count(c.in); got != c.want {
+			t.Errorf("Count(%q) = %d, want: %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestSplitElements(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+		msg  string
+	}{
+		{"", nil, ""},
+		{"for=192.0.2.43", []string{"for=192.0.2.43"}, ""},
+		{"for=192.0.2.43 , for=198.51.100.17;by=_a", []string{"for=192.0.2.43", "for=198.51.100.17;by=_a"}, ""},
+		{`for=192.0.2.43;host="a,b", for=unknown`, []string{`for=192.0.2.43;host="a,b"`, "for=unknown"}, ""},
+		{`key="\",\"", for=unknown`, []string{`key="\",\""`, "for=unknown"}, ""},
+		{"for=192.0.2.43,,", []string{"for=192.0.2.43"}, ""},
+		{"for=a,,for=b", []string{"for=a", "for=b"}, ""},
+		{" , ,", nil, ""},
+		{`for=192.0.2.43, host="a,b`, nil, "unterminated quoted-string"},
+	}
+
+	for _, c := range cases {
+		got, err := SplitElements(c.in)
+		var msg string
+		if err != nil {
+			msg = err.(*ParseError).Msg
+		}
+		if !reflect.DeepEqual(got, c.want) || msg != c.msg {
+			t.Errorf("SplitElements(%q) = (%q, %v), want: (%q, %q)", c.in, got, err, c.want, c.msg)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, c := range parseTests {
+		f.Add(c.in)
+	}
+	for _, c := range parseErrorTests {
+		f.Add(c.in)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		for elem, err := range Parse(line, false) {
+			if err != nil {
+				return
+			}
+			s := elem.String()
+			if s == "" {
+				continue // empty well-known parameters are omitted
+			}
+			got, err := ParseAll(s, false)
+			if err != nil {
+				t.Fatalf("ParseAll(%q) of %q error: %v", s, line, err)
+			}
+			if len(got) != 1 || !reflect.DeepEqual(got[0], elem) {
+				t.Fatalf("ParseAll(%q) of %q = %v, want: %#v", s, line, got, elem)
+			}
+		}
+	})
+}
+
+func TestParseShared(t *testing.T) {
+	for _, c := range parseTests {
+		for _, reverse := range []bool{false, true} {
+			var got []*Element
+			var shared *Element
+			for elem, err := range ParseShared(c.in, reverse) {
+				if err != nil {
+					t.Fatalf("%s: got error: %v", c.name, err)
+				}
+				if shared == nil {
+					shared = elem
+				} else if elem != shared {
+					t.Errorf("%s: element is not shared", c.name)
+				}
+				got = append(got, elem.Clone())
+			}
+			if reverse {
+				slices.Reverse(got)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s (reverse=%v):\ngot:  %v\nwant: %v", c.name, reverse, got, c.want)
+			}
+		}
+	}
+
+	for _, c := range parseErrorTests {
+		var err error
+		for _, err = range ParseShared(c.in, false) {
+			if err != nil {
+				break
+			}
+		}
+		if pe, ok := err.(*ParseError); !ok || pe.Msg != c.msg {
+			t.Errorf("ParseShared(%s) error = %v, want %q", c.in, err, c.msg)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	line := "for=192.0.2.43, for=198.51.100.17, for=203.0.113.60"
+	cases := []struct {
+		max  int
+		want []Node
+		msg  string
+	}{
+		{0, []Node{"192.0.2.43", "198.51.100.17", "203.0.113.60"}, ""},
+		{-1, []Node{"192.0.2.43", "198.51.100.17", "203.0.113.60"}, ""},
+		{3, []Node{"192.0.2.43", "198.51.100.17", "203.0.113.60"}, ""},
+		{2, []Node{"192.0.2.43", "198.51.100.17"}, "too many elements"},
+		{1, []Node{"192.0.2.43"}, "too many elements"},
+	}
+
+	for _, c := range cases {
+		for _, reverse := range []bool{false, true} {
+			var got []Node
+			var msg string
+			for elem, err := range ParseLimit(line, reverse, c.max) {
+				if err != nil {
+					msg = err.(*ParseError).Msg
+					break
+				}
+				got = append(got, elem.For)
+			}
+			want := c.want
+			if reverse {
+				want = []Node{"203.0.113.60", "198.51.100.17", "192.0.2.43"}[:len(c.want)]
+			}
+			if !slices.Equal(got, want) || msg != c.msg {
+				t.Errorf("ParseLimit(%d, %v) = %q, %q, want: %q, %q", c.max, reverse, got, msg, want, c.msg)
+			}
+		}
+	}
+}
+
+func TestFirst(t *testing.T) {
+	cases := []struct {
+		in   string
+		want *Element
+		msg  string
+	}{
+		{"", nil, ""},
+		{" ", nil, ""},
+		{"for=192.0.2.43", &Element{For: "192.0.2.43"}, ""},
+		{"for=192.0.2.43;proto=https, for=198.51.100.17", &Element{For: "192.0.2.43", Proto: "https"}, ""},
+		{"for=192.0.2.43, for", &Element{For: "192.0.2.43"}, ""},
+		{"for, for=192.0.2.43", nil, `no "=" found in`},
+	}
+
+	for _, c := range cases {
+		got, err := First(c.in)
+		var msg string
+		if err != nil {
+			msg = err.(*ParseError).Msg
+		}
+		if !reflect.DeepEqual(got, c.want) || msg != c.msg {
+			t.Errorf("First(%q) = %v, %q, want: %v, %q", c.in, got, msg, c.want, c.msg)
+		}
+	}
+}
+
+func TestNth(t *testing.T) {
+	line := "for=192.0.2.43, for=198.51.100.17;proto=https, for=_hidden"
+	cases := []struct {
+		in   string
+		n    int
+		want *Element
+		msg  string
+	}{
+		{"", 0, nil, ""},
+		{" ", -1, nil, ""},
+		{line, 0, &Element{For: "192.0.2.43"}, ""},
+		{line, 1, &Element{For: "198.51.100.17", Proto: "https"}, ""},
+		{line, 2, &Element{For: "_hidden"}, ""},
+		{line, 3, nil, ""},
+		{line, -1, &Element{For: "_hidden"}, ""},
+		{line, -3, &Element{For: "192.0.2.43"}, ""},
+		{line, -4, nil, ""},
+		{"for=192.0.2.43, for", 0, &Element{For: "192.0.2.43"}, ""},
+		{"for=192.0.2.43, for", 1, nil, `no "=" found in`},
+		{"for, for=192.0.2.43", -1, &Element{For: "192.0.2.43"}, ""},
+		{"for, for=192.0.2.43", 1, nil, `no "=" found in`},
+	}
+
+	for _, c := range cases {
+		got, err := Nth(c.in, c.n)
+		var msg string
+		if err != nil {
+			msg = err.(*ParseError).Msg
+		}
+		if !reflect.DeepEqual(got, c.want) || msg != c.msg {
+			t.Errorf("Nth(%q, %d) = %v, %q, want: %v, %q", c.in, c.n, got, msg, c.want, c.msg)
+		}
+	}
+}
+
+func TestForNodes(t *testing.T) {
+	line := "for=192.0.2.43;by=_a, proto=https;by=_b, for=_hidden"
+	cases := []struct {
+		nodes   func(string, bool) iter.Seq2[Node, error]
+		reverse bool
+		want    []Node
+	}{
+		{ForNodes, false, []Node{"192.0.2.43", "_hidden"}},
+		{ForNodes, true, []Node{"_hidden", "192.0.2.43"}},
+		{ByNodes, false, []Node{"_a", "_b"}},
+		{ByNodes, true, []Node{"_b", "_a"}},
+	}
+
+	for _, c := range cases {
+		var got []Node
+		for n, err := range c.nodes(line, c.reverse) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, n)
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf("nodes(reverse=%v) = %v, want: %v", c.reverse, got, c.want)
+		}
+	}
+
+	var got []Node
+	var n Node
+	var err error
+	for n, err = range ForNodes("for=192.0.2.43, for", false) {
+		if err != nil {
+			break
+		}
+		got = append(got, n)
+	}
+	if !slices.Equal(got, []Node{"192.0.2.43"}) || !errors.Is(err, ErrNoEquals) {
+		t.Errorf("ForNodes(invalid) = %v, %v, want: [192.0.2.43], %v", got, err, ErrNoEquals)
+	}
+
+	for range ForNodes("for=_a, for=_b", true) {
+		break
+	}
+}
+
+func TestFirstRequest(t *testing.T) {
+	r := &http.Request{Header: http.Header{}}
+	if got, err := FirstRequest(r); got != nil || err != nil {
+		t.Errorf("FirstRequest(no header) = %v, %v, want: nil, nil", got, err)
+	}
+
+	r.Header.Set("Forwarded", "for=192.0.2.43, for=198.51.100.17")
+	got, err := FirstRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Element{For: "192.0.2.43"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("FirstRequest() = %v, want: %v", got, want)
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	for _, c := range parseTests {
+		got, err := ParseAll(c.in, false)
+		if err != nil {
+			t.Fatalf("%s: got error: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\ngot:  %v\nwant: %v", c.name, got, c.want)
+		}
+
+		got, err = ParseAll(c.in, true)
+		if err != nil {
+			t.Fatalf("%s: got error: %v", c.name, err)
+		}
+		slices.Reverse(got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s reversed:\ngot:  %v\nwant: %v", c.name, got, c.want)
+		}
+	}
+
+	for _, c := range parseErrorTests {
+		got, err := ParseAll(c.in, false)
+		if pe, ok := err.(*ParseError); !ok || pe.Msg != c.msg {
+			t.Errorf("ParseAll(%s) error = %v, want %q", c.in, err, c.msg)
+		}
+		if got != nil {
+			t.Errorf("ParseAll(%s) = %v, want nil on error", c.in, got)
+		}
+	}
+}
+
+func TestEnds(t *testing.T) {
+	first, last, err := Ends("")
+	if first != nil || last != nil || err != nil {
+		t.Errorf(`Ends("") = (%v, %v, %v), want: (nil, nil, nil)`, first, last, err)
+	}
+
+	first, last, err = Ends("for=192.0.2.43")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != last || first.For != "192.0.2.43" {
+		t.Errorf("Ends(single) = (%v, %v), want same element", first, last)
+	}
+
+	first, last, err = Ends("for=192.0.2.43, for=198.51.100.17;by=203.0.113.60, for=unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.For != "192.0.2.43" || last.For != "unknown" {
+		t.Errorf("Ends(multi) = (%v, %v), want: (for=192.0.2.43, for=unknown)", first, last)
+	}
+
+	_, _, err = Ends("for=192.0.2.43, for")
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("Ends(invalid) error = %v, want *ParseError", err)
+	}
+}
+
+func TestAppendRequest(t *testing.T) {
+	r := &http.Request{Header: http.Header{}}
+	r.Header.Add("Forwarded", "for=192.0.2.43, for=198.51.100.17")
+	r.Header.Add("Forwarded", "for=203.0.113.60")
+
+	AppendRequest(r, &Element{For: "10.0.0.1", Proto: "https"}, &Element{}, &Element{For: "10.0.0.2"})
+
+	wantFields := []string{
+		"for=192.0.2.43, for=198.51.100.17",
+		"for=203.0.113.60, for=10.0.0.1;proto=https, for=10.0.0.2",
+	}
+	if got := r.Header.Values("Forwarded"); !slices.Equal(got, wantFields) {
+		t.Errorf("header fields = %q, want: %q", got, wantFields)
+	}
+
+	var got []Node
+	for elem, err := range ParseRequest(r, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, elem.For)
+	}
+	want := []Node{"192.0.2.43", "198.51.100.17", "203.0.113.60", "10.0.0.1", "10.0.0.2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseRequest() = %q, want: %q", got, want)
+	}
+
+	r = &http.Request{}
+	AppendRequest(r, &Element{For: "192.0.2.43"})
+	if got := r.Header.Values("Forwarded"); !slices.Equal(got, []string{"for=192.0.2.43"}) {
+		t.Errorf("header fields = %q, want: %q", got, "for=192.0.2.43")
+	}
+
+	r = &http.Request{Header: http.Header{"Forwarded": {""}}}
+	AppendRequest(r, &Element{For: "192.0.2.43"})
+	if got := r.Header.Values("Forwarded"); !slices.Equal(got, []string{"for=192.0.2.43"}) {
+		t.Errorf("header fields = %q, want: %q", got, "for=192.0.2.43")
+	}
+}
+
+func TestSetRequest(t *testing.T) {
+	r := &http.Request{}
+	SetRequest(r, &Element{For: "a"})
+	SetRequest(r, &Element{})
+	SetRequest(r, nil)
+	SetRequest(r, &Element{For: "b"})
+	if got, want := r.Header.Values("Forwarded"), []string{"for=a, for=b"}; !slices.Equal(got, want) {
+		t.Errorf("header fields = %q, want: %q", got, want)
+	}
+
+	r = &http.Request{Header: http.Header{}}
+	r.Header.Add("Forwarded", "for=192.0.2.43")
+	r.Header.Add("Forwarded", "")
+	r.Header.Add("Forwarded", "for=198.51.100.17")
+	SetRequest(r, &Element{For: "10.0.0.1", Proto: "https"})
+	want := []string{"for=192.0.2.43, for=198.51.100.17, for=10.0.0.1;proto=https"}
+	if got := r.Header.Values("Forwarded"); !slices.Equal(got, want) {
+		t.Errorf("header fields = %q, want: %q", got, want)
+	}
+
+	r = &http.Request{}
+	SetRequest(r, &Element{})
+	if r.Header != nil {
+		t.Errorf("header = %v, want nil for empty element", r.Header)
+	}
+}
+
+func TestParseRequestFields(t *testing.T) {
+	r := &http.Request{Header: http.Header{}}
+	r.Header.Add("Forwarded", "for=192.0.2.43;proto=https, for=198.51.100.17")
+	r.Header.Add("Forwarded", "for=203.0.113.60;by=_proxy")
+
+	for _, reverse := range []bool{false, true} {
+		var got []*Element
+		for elem, err := range ParseRequest(r, reverse) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, elem)
+		}
+		if reverse {
+			slices.Reverse(got)
+		}
+
+		want := []*Element{
+			{For: "192.0.2.43", Proto: "https"},
+			{For: "198.51.100.17"},
+			{For: "203.0.113.60", By: "_proxy"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseRequest(reverse=%v):\ngot:  %v\nwant: %v", reverse, got, want)
+		}
+	}
+
+	if e, err := FirstRequest(r); err != nil || e.For != "192.0.2.43" {
+		t.Errorf("FirstRequest() = %v, %v, want for=192.0.2.43", e, err)
+	}
+	if e, err := LastRequest(r); err != nil || e.For != "203.0.113.60" {
+		t.Errorf("LastRequest() = %v, %v, want for=203.0.113.60", e, err)
+	}
+}
+
+func TestParseRequestEmptyField(t *testing.T) {
+	r := &http.Request{Header: http.Header{}}
+	r.Header.Add("Forwarded", "for=192.0.2.43, for=198.51.100.17")
+	r.Header.Add("Forwarded", "")
+	r.Header.Add("Forwarded", "for=203.0.113.60")
+
+	for _, reverse := range []bool{false, true} {
+		var got []Node
+		for elem, err := range ParseRequest(r, reverse) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, elem.For)
+		}
+
+		want := []Node{"192.0.2.43", "198.51.100.17", "203.0.113.60"}
+		if reverse {
+			slices.Reverse(want)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("ParseRequest(reverse=%v) = %q, want: %q", reverse, got, want)
+		}
+	}
+}
+
+func TestParseAllRequest(t *testing.T) {
+	r := &http.Request{Header: http.Header{}}
+	r.Header.Add("Forwarded", "for=192.0.2.43, for=198.51.100.17")
+	r.Header.Add("Forwarded", "for=203.0.113.60")
+
+	got, err := ParseAllRequest(r, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Element{{For: "203.0.113.60"}, {For: "198.51.100.17"}, {For: "192.0.2.43"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAllRequest() = %v, want: %v", got, want)
+	}
+
+	r.Header.Add("Forwarded", "for")
+	if got, err := ParseAllRequest(r, false); got != nil || err == nil {
+		t.Errorf("ParseAllRequest(invalid) = %v, %v, want error", got, err)
+	}
+}
+
+func TestParseNamedRequest(t *testing.T) {
+	r := &http.Request{Header: http.Header{}}
+	r.Header.Add("Forwarded", "for=203.0.113.60")
+	r.Header.Add("X-Internal-Forwarded", "for=192.0.2.43;proto=https")
+	r.Header.Add("X-Internal-Forwarded", "for=10.0.0.1")
+
+	var got []*Element
+	for elem, err := range ParseNamedRequest(r, "x-internal-forwarded", true) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, elem)
+	}
+
+	want := []*Element{
+		{For: "10.0.0.1"},
+		{For: "192.0.2.43", Proto: "https"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot:  %v\nwant: %v", got, want)
+	}
+}
+
+func TestRequestHasForwarded(t *testing.T) {
+	r := &http.Request{Header: http.Header{}}
+	if RequestHasForwarded(r) {
+		t.Error("RequestHasForwarded() = true for missing header")
+	}
+	r.Header.Set("Forwarded", "")
+	if !RequestHasForwarded(r) {
+		t.Error("RequestHasForwarded() = false for empty header")
+	}
+	r.Header.Set("Forwarded", "for=192.0.2.43")
+	if !RequestHasForwarded(r) {
+		t.Error("RequestHasForwarded() = false for populated header")
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Add("Forwarded", "for=192.0.2.43;proto=https")
+	resp.Header.Add("Forwarded", "for=198.51.100.17;by=203.0.113.60")
+
+	var got []*Element
+	for elem, err := range ParseResponse(resp, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, elem)
+	}
+
+	want := []*Element{
+		{For: "192.0.2.43", Proto: "https"},
+		{For: "198.51.100.17", By: "203.0.113.60"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot:  %v\nwant: %v", got, want)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	collect := func(md map[string][]string, reverse bool) []Node {
+		var nodes []Node
+		for elem, err := range ParseMetadata(md, reverse) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodes = append(nodes, elem.For)
+		}
+		return nodes
+	}
+
+	md := map[string][]string{
+		"forwarded": {"for=192.0.2.43"},
+	}
+	if got, want := collect(md, false), []Node{"192.0.2.43"}; !slices.Equal(got, want) {
+		t.Errorf("one value: got %v, want: %v", got, want)
+	}
+
+	md = map[string][]string{
+		"forwarded":  {"for=192.0.2.43, for=198.51.100.17", "for=unknown"},
+		"user-agent": {"grpc-go"},
+	}
+	if got, want := collect(md, false), []Node{"192.0.2.43", "198.51.100.17", "unknown"}; !slices.Equal(got, want) {
+		t.Errorf("multiple values: got %v, want: %v", got, want)
+	}
+	if got, want := collect(md, true), []Node{"unknown", "198.51.100.17", "192.0.2.43"}; !slices.Equal(got, want) {
+		t.Errorf("multiple values reversed: got %v, want: %v", got, want)
+	}
+}
+
+func BenchmarkElementString(b *testing.B) {
+	elems := []*Element{
+		{For: "192.0.2.43:47011", Proto: "https", Host: "example.com"},
+		{For: "[2001:db8:cafe::17]", By: "_proxy", Extra: []Parameter{{"key", "a b"}}},
+		{For: "unknown", By: "203.0.113.60"},
+	}
+
+	b.Run("String", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			var s string
+			for i, e := range elems {
+				if i > 0 {
+					s += ", "
+				}
+				s += e.String()
+			}
+		}
+	})
+
+	b.Run("AppendTo", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for b.Loop() {
+			buf = buf[:0]
+			for i, e := range elems {
+				if i > 0 {
+					buf = append(buf, ", "...)
+				}
+				buf = e.AppendTo(buf)
 			}
-			got = append(got, elem)
 		}
+	})
+}
 
-		want := c.want
-		if reverse {
-			want = slices.Clone(want)
-			slices.Reverse(want)
+func BenchmarkParseShared(b *testing.B) {
+	line := `for=192.0.2.43;a=b, for="[2001:db8:cafe::17]:4711";by=203.0.113.60;proto=https;host=example.com, for=unknown`
+
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, err := range Parse(line, false) {
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
 		}
+	})
 
-		if !reflect.DeepEqual(got, want) {
-			t.Errorf("\ngot:  %v\nwant: %v", got, want)
+	b.Run("shared", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, err := range ParseShared(line, false) {
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
 		}
-	}
+	})
 }
 
 func BenchmarkParse(b *testing.B) {
@@ -159,6 +1193,47 @@ func BenchmarkParse(b *testing.B) {
 	}
 }
 
+func TestElementProtoAllowed(t *testing.T) {
+	cases := []struct {
+		proto   string
+		allowed []string
+		want    bool
+	}{
+		{"https", []string{"https"}, true},
+		{"HTTPS", []string{"http", "https"}, true},
+		{"http", []string{"https"}, false},
+		{"", []string{"https", ""}, false},
+		{"https", nil, false},
+	}
+
+	for _, c := range cases {
+		got := Element{Proto: c.proto}.ProtoAllowed(c.allowed...)
+		if got != c.want {
+			t.Errorf("Element{Proto: %q}.ProtoAllowed(%q) = %v, want: %v", c.proto, c.allowed, got, c.want)
+		}
+	}
+}
+
+func TestElementProtoIsSecure(t *testing.T) {
+	cases := []struct {
+		proto string
+		want  bool
+	}{
+		{"https", true},
+		{"HTTPS", true},
+		{"Https", true},
+		{"http", false},
+		{"wss", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		if got := (&Element{Proto: c.proto}).ProtoIsSecure(); got != c.want {
+			t.Errorf("Element{Proto: %q}.ProtoIsSecure() = %v, want: %v", c.proto, got, c.want)
+		}
+	}
+}
+
 func TestNode(t *testing.T) {
 	t.Run("AddrPort", func(t *testing.T) {
 		cases := []struct {
@@ -190,6 +1265,136 @@ func TestNode(t *testing.T) {
 		}
 	})
 
+	t.Run("AddrPortAccessors", func(t *testing.T) {
+		cases := []struct {
+			node   Node
+			addr   string
+			port   NodePort
+			addrOK bool
+			portOK bool
+		}{
+			{"192.0.2.43", "192.0.2.43", "", true, false},
+			{"192.0.2.43:4711", "192.0.2.43", "4711", true, true},
+			{"[2001:db8:cafe::17]", "2001:db8:cafe::17", "", true, false},
+			{"[2001:db8:cafe::17]:_p0rt", "2001:db8:cafe::17", "_p0rt", true, true},
+			{"_gazonk:4711", "", "4711", false, true},
+			{"unknown", "", "", false, false},
+		}
+		for _, c := range cases {
+			var want netip.Addr
+			if c.addr != "" {
+				want = netip.MustParseAddr(c.addr)
+			}
+			if got, ok := c.node.Addr(); got != want || ok != c.addrOK {
+				t.Errorf("Node(%q).Addr() = %v, %v, want: %v, %v", c.node, got, ok, want, c.addrOK)
+			}
+			if got, ok := c.node.Port(); got != c.port || ok != c.portOK {
+				t.Errorf("Node(%q).Port() = %q, %v, want: %q, %v", c.node, got, ok, c.port, c.portOK)
+			}
+		}
+	})
+
+	t.Run("Zone", func(t *testing.T) {
+		cases := []struct {
+			node Node
+			addr string
+			port NodePort
+			ok   bool
+		}{
+			{"[fe80::1%eth0]", "fe80::1%eth0", "", true},
+			{"[fe80::1%eth0]:8080", "fe80::1%eth0", "8080", true},
+			{"[fe80::1%25eth0]:8080", "fe80::1%25eth0", "8080", true},
+			{"[fe80::1%2501]", "fe80::1%2501", "", true},
+			{"[fe80::1%1]:8080", "fe80::1%1", "8080", true},
+			{"[fe80::1]:8080", "fe80::1", "8080", true},
+			{"[fe80::1%]", "", "", false},
+			{"192.0.2.43%eth0", "", "", false},
+		}
+		for _, c := range cases {
+			addr, port, ok := c.node.AddrPort()
+			var want netip.Addr
+			if c.addr != "" {
+				want = netip.MustParseAddr(c.addr)
+			}
+			if addr != want || port != c.port || ok != c.ok {
+				t.Errorf("Node(%q).AddrPort() = %v, %q, %v, want: %v, %q, %v",
+					c.node, addr, port, ok, want, c.port, c.ok)
+			}
+			if addr.Zone() != want.Zone() {
+				t.Errorf("Node(%q).AddrPort() zone = %q, want: %q", c.node, addr.Zone(), want.Zone())
+			}
+		}
+	})
+
+	t.Run("NetipAddrPort", func(t *testing.T) {
+		cases := []struct {
+			node Node
+			want string
+			ok   bool
+		}{
+			{"192.0.2.43:4711", "192.0.2.43:4711", true},
+			{"[2001:db8:cafe::17]:4711", "[2001:db8:cafe::17]:4711", true},
+			{"192.0.2.43", "", false},
+			{"[2001:db8:cafe::17]", "", false},
+			{"192.0.2.43:_hidden", "", false},
+			{"192.0.2.43:65536", "", false},
+			{"_gazonk:4711", "", false},
+			{"unknown", "", false},
+		}
+		for _, c := range cases {
+			got, ok := c.node.NetipAddrPort()
+			var want netip.AddrPort
+			if c.want != "" {
+				want = netip.MustParseAddrPort(c.want)
+			}
+			if got != want || ok != c.ok {
+				t.Errorf("Node(%q).NetipAddrPort() = %v, %v, want: %v, %v", c.node, got, ok, want, c.ok)
+			}
+		}
+	})
+
+	t.Run("IsIP", func(t *testing.T) {
+		cases := []struct {
+			node Node
+			want bool
+		}{
+			{"192.0.2.1", true},
+			{"192.0.2.1:80", true},
+			{"[2001:db8::1]", true},
+			{"[2001:db8::1]:_p0rt", true},
+			{"_gazonk", false},
+			{"_gazonk:80", false},
+			{"unknown", false},
+			{"", false},
+		}
+		for _, c := range cases {
+			if got := c.node.IsIP(); got != c.want {
+				t.Errorf("Node(%q).IsIP() = %v, want: %v", c.node, got, c.want)
+			}
+		}
+	})
+
+	t.Run("IsPlainIP", func(t *testing.T) {
+		cases := []struct {
+			node Node
+			want bool
+		}{
+			{"192.0.2.1", true},
+			{"[2001:db8::1]", true},
+			{"192.0.2.1:80", false},
+			{"[2001:db8::1]:80", false},
+			{"2001:db8::1", false},
+			{"[192.0.2.1]", false},
+			{"_gazonk", false},
+			{"unknown", false},
+		}
+		for _, c := range cases {
+			if got := c.node.IsPlainIP(); got != c.want {
+				t.Errorf("Node(%q).IsPlainIP() = %v, want: %v", c.node, got, c.want)
+			}
+		}
+	})
+
 	t.Run("IsObfuscated", func(t *testing.T) {
 		obf := Node("_gazonk").IsObfuscated()
 		if !obf {
@@ -201,6 +1406,26 @@ func TestNode(t *testing.T) {
 		}
 	})
 
+	t.Run("IsAmbiguous", func(t *testing.T) {
+		cases := []struct {
+			node Node
+			want bool
+		}{
+			{"_192.0.2.1", true},
+			{"_192.0.2.1:4711", true},
+			{"_[2001:db8::1]", true},
+			{"_gazonk", false},
+			{"_gazonk:4711", false},
+			{"192.0.2.1", false},
+			{"unknown", false},
+		}
+		for _, c := range cases {
+			if got := c.node.IsAmbiguous(); got != c.want {
+				t.Errorf("Node(%q).IsAmbiguous() = %v, want: %v", c.node, got, c.want)
+			}
+		}
+	})
+
 	t.Run("IsUnknown", func(t *testing.T) {
 		unk := Node("unknown").IsUnknown()
 		if !unk {
@@ -213,6 +1438,117 @@ func TestNode(t *testing.T) {
 	})
 }
 
+func TestNodeFromNetAddr(t *testing.T) {
+	cases := []struct {
+		addr net.Addr
+		want Node
+		ok   bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.43"), Port: 47011}, "192.0.2.43:47011", true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.43")}, "192.0.2.43", true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8:cafe::17"), Port: 47011}, "[2001:db8:cafe::17]:47011", true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8:cafe::17")}, "[2001:db8:cafe::17]", true},
+		{&net.UDPAddr{IP: net.ParseIP("192.0.2.43"), Port: 47011}, "192.0.2.43:47011", true},
+		{&net.IPAddr{IP: net.ParseIP("192.0.2.43")}, "", false},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, "", false},
+		{(*net.TCPAddr)(nil), "", false},
+		{nil, "", false},
+	}
+
+	for _, c := range cases {
+		got, ok := NodeFromNetAddr(c.addr)
+		if got != c.want || ok != c.ok {
+			t.Errorf("NodeFromNetAddr(%v) = (%q, %v), want: (%q, %v)", c.addr, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestNodeFromAddrPort(t *testing.T) {
+	cases := []struct {
+		ap   netip.AddrPort
+		want Node
+	}{
+		{netip.MustParseAddrPort("192.0.2.43:47011"), "192.0.2.43:47011"},
+		{netip.MustParseAddrPort("192.0.2.43:0"), "192.0.2.43"},
+		{netip.MustParseAddrPort("[2001:db8:cafe::17]:47011"), "[2001:db8:cafe::17]:47011"},
+		{netip.MustParseAddrPort("[2001:db8:cafe::17]:0"), "[2001:db8:cafe::17]"},
+		{netip.MustParseAddrPort("[::ffff:192.0.2.43]:80"), "192.0.2.43:80"},
+		{netip.AddrPortFrom(netip.Addr{}, 80), ""},
+	}
+
+	for _, c := range cases {
+		if got := NodeFromAddrPort(c.ap); got != c.want {
+			t.Errorf("NodeFromAddrPort(%v) = %q, want: %q", c.ap, got, c.want)
+		}
+		if got, want := NodeFromAddr(c.ap.Addr()), NodeFromAddrPort(netip.AddrPortFrom(c.ap.Addr(), 0)); got != want {
+			t.Errorf("NodeFromAddr(%v) = %q, want: %q", c.ap.Addr(), got, want)
+		}
+		if got := NodeFromAddrPort(c.ap); got != "" {
+			if addr, ok := got.Addr(); !ok || addr != c.ap.Addr().Unmap() {
+				t.Errorf("NodeFromAddrPort(%v).Addr() = %v, %v", c.ap, addr, ok)
+			}
+		}
+	}
+}
+
+func TestNodeWithPort(t *testing.T) {
+	cases := []struct {
+		n    Node
+		p    NodePort
+		want Node
+		ok   bool
+	}{
+		{"192.0.2.43", "4711", "192.0.2.43:4711", true},
+		{"192.0.2.43:80", "4711", "192.0.2.43:4711", true},
+		{"192.0.2.43:80", "", "192.0.2.43", true},
+		{"[2001:db8:cafe::17]", "4711", "[2001:db8:cafe::17]:4711", true},
+		{"[2001:db8:cafe::17]:80", "_p", "[2001:db8:cafe::17]:_p", true},
+		{"[2001:db8:cafe::17]:80", "", "[2001:db8:cafe::17]", true},
+		{"unknown", "0", "unknown:0", true},
+		{"_gazonk", NodePortFromUint16(443), "_gazonk:443", true},
+		{"", "80", "", false},
+		{"192.0.2.43", "0443", "", false},
+		{"192.0.2.43", "65536", "", false},
+		{"192.0.2.43", "_", "", false},
+		{"192.0.2.43", "_ga zonk", "", false},
+	}
+
+	for _, c := range cases {
+		got, ok := c.n.WithPort(c.p)
+		if got != c.want || ok != c.ok {
+			t.Errorf("Node(%q).WithPort(%q) = (%q, %v), want: (%q, %v)", c.n, c.p, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestNodeIsValidObfuscated(t *testing.T) {
+	cases := []struct {
+		n    Node
+		want bool
+	}{
+		{"_gazonk", true},
+		{"_hidden.proxy-1_a", true},
+		{"_gazonk:4711", true},
+		{"_gazonk:_p", true},
+		{"_", false},
+		{"_ga zonk", false},
+		{"_ga;zonk", false},
+		{"gazonk", false},
+		{"192.0.2.43", false},
+		{"unknown", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		if got := c.n.IsValidObfuscated(); got != c.want {
+			t.Errorf("Node(%q).IsValidObfuscated() = %v, want: %v", c.n, got, c.want)
+		}
+		if c.want && !c.n.IsObfuscated() {
+			t.Errorf("Node(%q).IsObfuscated() = false, want: true", c.n)
+		}
+	}
+}
+
 func TestNodePort(t *testing.T) {
 	t.Run("Uint16", func(t *testing.T) {
 		port, ok := NodePort("47011").Uint16()
@@ -225,6 +1561,59 @@ func TestNodePort(t *testing.T) {
 		}
 	})
 
+	t.Run("FromUint16", func(t *testing.T) {
+		for _, p := range []uint16{0, 80, 443, 65535} {
+			np := NodePortFromUint16(p)
+			if got, ok := np.Uint16(); got != p || !ok {
+				t.Errorf("NodePortFromUint16(%d).Uint16() = (%d, %v), want: (%d, true)", p, got, ok, p)
+			}
+		}
+		if got := NodePortFromUint16(8080); got != "8080" {
+			t.Errorf("NodePortFromUint16(8080) = %q, want: %q", got, "8080")
+		}
+	})
+
+	t.Run("Equal", func(t *testing.T) {
+		cases := []struct {
+			a, b NodePort
+			want bool
+		}{
+			{"443", "443", true},
+			{"443", "0443", true},
+			{"443", "80", false},
+			{"_gazonk", "_gazonk", true},
+			{"_gazonk", "_GAZONK", false},
+			{"_443", "443", false},
+			{"", "", true},
+			{"", "0", false},
+		}
+		for _, c := range cases {
+			if got := c.a.Equal(c.b); got != c.want {
+				t.Errorf("NodePort(%q).Equal(%q) = %v, want: %v", c.a, c.b, got, c.want)
+			}
+		}
+	})
+
+	t.Run("IsValidObfuscated", func(t *testing.T) {
+		cases := []struct {
+			port NodePort
+			want bool
+		}{
+			{"_gazonk", true},
+			{"_ga.zo_nk-1", true},
+			{"_ga zonk", false},
+			{"_ga:zonk", false},
+			{"_", false},
+			{"gazonk", false},
+			{"47011", false},
+		}
+		for _, c := range cases {
+			if got := c.port.IsValidObfuscated(); got != c.want {
+				t.Errorf("NodePort(%q).IsValidObfuscated() = %v, want: %v", c.port, got, c.want)
+			}
+		}
+	})
+
 	t.Run("IsObfuscated", func(t *testing.T) {
 		obf := NodePort("_gazonk").IsObfuscated()
 		if !obf {
@@ -236,3 +1625,367 @@ func TestNodePort(t *testing.T) {
 		}
 	})
 }
+
+func TestElementAppendTo(t *testing.T) {
+	var buf []byte
+	for _, c := range parseTests {
+		buf = buf[:0]
+		for i, e := range c.want {
+			if i > 0 {
+				buf = append(buf, ", "...)
+			}
+			buf = e.AppendTo(buf)
+		}
+
+		var want []string
+		for _, e := range c.want {
+			want = append(want, e.String())
+		}
+		if got := string(buf); got != strings.Join(want, ", ") {
+			t.Errorf("%s: AppendTo() = %s, want: %s", c.name, got, strings.Join(want, ", "))
+		}
+	}
+
+	e := &Element{For: "192.0.2.43:4711", Extra: []Parameter{{"key", `a"b`}}}
+	if got, want := string(e.AppendTo([]byte("x "))), `x for="192.0.2.43:4711";key="a\"b"`; got != want {
+		t.Errorf("AppendTo() = %s, want: %s", got, want)
+	}
+}
+
+func TestElementStringSorted(t *testing.T) {
+	e := Element{
+		Host:  "example.com",
+		For:   "192.0.2.43",
+		Extra: []Parameter{{"z", "1"}, {"a", "2"}, {"m", "3"}, {"a", "4"}},
+	}
+	want := "for=192.0.2.43;host=example.com;a=2;a=4;m=3;z=1"
+	if got := e.StringSorted(); got != want {
+		t.Errorf("StringSorted() = %s, want: %s", got, want)
+	}
+	if e.Extra[0].Key != "z" {
+		t.Errorf("StringSorted() modified Extra: %v", e.Extra)
+	}
+	if got, want := (Element{For: "unknown"}).StringSorted(), "for=unknown"; got != want {
+		t.Errorf("StringSorted() = %s, want: %s", got, want)
+	}
+}
+
+func TestElementText(t *testing.T) {
+	for _, c := range parseTests {
+		for _, want := range c.want {
+			text, err := want.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got Element
+			if err := got.UnmarshalText(text); err != nil {
+				t.Fatalf("%s: UnmarshalText(%s) error: %v", c.name, text, err)
+			}
+			if !reflect.DeepEqual(&got, want) {
+				t.Errorf("%s: round-trip = %v, want: %v", c.name, got, want)
+			}
+		}
+	}
+
+	e := Element{For: "192.0.2.43"}
+	cases := []struct {
+		in  string
+		msg string
+	}{
+		{"for=192.0.2.43, for=198.51.100.17", "multiple elements in"},
+		{"for=192.0.2.43,, for=198.51.100.17,", "multiple elements in"},
+		{"for=192.0.2.43;by", `no "=" found in`},
+		{`for="192.0.2.43`, "unterminated quoted-string"},
+	}
+	for _, c := range cases {
+		err := e.UnmarshalText([]byte(c.in))
+		if pe, ok := err.(*ParseError); !ok || pe.Msg != c.msg {
+			t.Errorf("UnmarshalText(%s) error = %v, want %q", c.in, err, c.msg)
+		}
+		if e.For != "192.0.2.43" {
+			t.Errorf("UnmarshalText(%s) modified element on error: %v", c.in, e)
+		}
+	}
+
+	if err := e.UnmarshalText([]byte(`for=unknown;host="a,b"`)); err != nil || e.Host != "a,b" {
+		t.Errorf("UnmarshalText(quoted comma) = %v, %v", e, err)
+	}
+	for _, in := range []string{"for=198.51.100.17,", ", for=198.51.100.17 ,,"} {
+		if err := e.UnmarshalText([]byte(in)); err != nil || !reflect.DeepEqual(e, Element{For: "198.51.100.17"}) {
+			t.Errorf("UnmarshalText(%s) = %v, %v, want: for=198.51.100.17", in, e, err)
+		}
+	}
+	if err := e.UnmarshalText([]byte(", for=192.0.2.43;by")); err == nil || err.(*ParseError).Offset != 17 {
+		t.Errorf("UnmarshalText(stray comma) error = %v, want offset 17", err)
+	}
+	if err := e.UnmarshalText(nil); err != nil || !reflect.DeepEqual(e, Element{}) {
+		t.Errorf("UnmarshalText(empty) = %v, %v, want empty element", e, err)
+	}
+}
+
+func TestElementEqual(t *testing.T) {
+	e := &Element{
+		By:    "_proxy",
+		For:   "192.0.2.43:4711",
+		Proto: "https",
+		Host:  "example.com",
+		Extra: []Parameter{{"a", "1"}, {"b", "2"}},
+	}
+	cases := []struct {
+		other *Element
+		want  bool
+	}{
+		{e.Clone(), true},
+		{&Element{By: "_proxy", For: "192.0.2.43:4711", Proto: "https", Host: "example.com"}, false},
+		{&Element{By: "_other", For: "192.0.2.43:4711", Proto: "https", Host: "example.com", Extra: e.Extra}, false},
+		{&Element{By: "_proxy", For: "192.0.2.43", Proto: "https", Host: "example.com", Extra: e.Extra}, false},
+		{&Element{By: "_proxy", For: "192.0.2.43:4711", Proto: "http", Host: "example.com", Extra: e.Extra}, false},
+		{&Element{By: "_proxy", For: "192.0.2.43:4711", Proto: "https", Host: "example.org", Extra: e.Extra}, false},
+		{&Element{By: "_proxy", For: "192.0.2.43:4711", Proto: "https", Host: "example.com", Extra: []Parameter{{"b", "2"}, {"a", "1"}}}, false},
+		{&Element{By: "_proxy", For: "192.0.2.43:4711", Proto: "https", Host: "example.com", Extra: []Parameter{{"a", "1"}, {"b", "3"}}}, false},
+		{&Element{By: "_proxy", For: "192.0.2.43:4711", Proto: "HTTPS", Host: "example.com", Extra: []Parameter{{"A", "1"}, {"b", "2"}}}, true},
+		{&Element{By: "_proxy", For: "192.0.2.43:4711", Proto: "https", Host: "example.com", Extra: []Parameter{{"a", "1"}, {"b", "2"}, {"b", "2"}}}, false},
+		{&Element{By: "_proxy", For: "192.0.2.43:4711", Proto: "https", Host: "Example.com", Extra: e.Extra}, false},
+		{&Element{By: "_proxy", For: "192.0.2.43:4711", Proto: "https", Host: "example.com", Extra: []Parameter{{"a", "1"}, {"b", "B"}}}, false},
+		{nil, false},
+	}
+
+	for _, c := range cases {
+		if got := e.Equal(c.other); got != c.want {
+			t.Errorf("%v.Equal(%v) = %v, want: %v", e, c.other, got, c.want)
+		}
+		if got := c.other.Equal(e); got != c.want {
+			t.Errorf("%v.Equal(%v) = %v, want: %v", c.other, e, got, c.want)
+		}
+	}
+
+	if !(*Element)(nil).Equal(nil) {
+		t.Error("nil.Equal(nil) = false, want: true")
+	}
+	if !(&Element{}).Equal(&Element{Extra: []Parameter{}}) {
+		t.Error("Equal() with nil and empty extra = false, want: true")
+	}
+}
+
+func TestElementClone(t *testing.T) {
+	e := &Element{
+		By:    "203.0.113.43",
+		For:   "192.0.2.60",
+		Proto: "http",
+		Host:  "example.com",
+		Extra: []Parameter{{"key", "value"}},
+	}
+	want := &Element{
+		By:    "203.0.113.43",
+		For:   "192.0.2.60",
+		Proto: "http",
+		Host:  "example.com",
+		Extra: []Parameter{{"key", "value"}},
+	}
+
+	c := e.Clone()
+	if !reflect.DeepEqual(c, e) {
+		t.Errorf("Clone() = %v, want: %v", c, e)
+	}
+	c.Extra[0].Value = "changed"
+	c.Extra = append(c.Extra, Parameter{"other", "value"})
+	c.For = "unknown"
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("original after mutating clone = %v, want: %v", e, want)
+	}
+
+	if got := (*Element)(nil).Clone(); got != nil {
+		t.Errorf("nil.Clone() = %v, want nil", got)
+	}
+}
+
+func TestElementValidate(t *testing.T) {
+	cases := []struct {
+		e   Element
+		msg string
+	}{
+		{Element{}, ""},
+		{Element{For: "192.0.2.43", By: "_proxy", Proto: "https", Host: "example.com"}, ""},
+		{Element{For: "192.0.2.43:4711", By: "[2001:db8:cafe::17]:_p0rt"}, ""},
+		{Element{For: "unknown", Host: "[2001:db8::17]:8080", Extra: []Parameter{{"key", "a b"}}}, ""},
+		{Element{By: "example.com"}, "invalid by node"},
+		{Element{For: "2001:db8::17"}, "invalid for node"},
+		{Element{For: "[192.0.2.43]"}, "invalid for node"},
+		{Element{For: "192.0.2.43:65536"}, "invalid for node"},
+		{Element{For: "192.0.2.43:"}, "invalid for node"},
+		{Element{For: "[2001:db8::17]x"}, "invalid for node"},
+		{Element{For: "_bad node"}, "invalid for node"},
+		{Element{Proto: "ht tp"}, "invalid proto"},
+		{Element{Host: "example.com:http"}, "invalid host"},
+		{Element{Extra: []Parameter{{"", "value"}}}, "invalid token"},
+		{Element{Extra: []Parameter{{"a=b", "value"}}}, "invalid token"},
+		{Element{Extra: []Parameter{{"key", "a\nb"}}}, "invalid value"},
+		{Element{Extra: []Parameter{{"key", "\x7f"}}}, "invalid value"},
+	}
+
+	for _, c := range cases {
+		err := c.e.Validate()
+		var msg string
+		if err != nil {
+			msg = err.(*ParseError).Msg
+		}
+		if msg != c.msg {
+			t.Errorf("Validate(%v) error = %v, want: %q", c.e, err, c.msg)
+		}
+		if err != nil && strings.Contains(err.Error(), "offset") {
+			t.Errorf("Validate(%v) error %q has an offset", c.e, err)
+		}
+	}
+
+	for _, c := range parseTests {
+		for _, e := range c.want {
+			if err := e.Validate(); err != nil && !strings.HasPrefix(c.name, "quoted/semicolon") {
+				t.Errorf("%s: Validate(%v) error = %v", c.name, e, err)
+			}
+		}
+	}
+}
+
+func TestElementGet(t *testing.T) {
+	e := &Element{
+		For:   "192.0.2.60",
+		Proto: "https",
+		Extra: []Parameter{
+			{"Secret", "a b"},
+			{"secret", "other"},
+			{"empty", ""},
+		},
+	}
+
+	cases := []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{"for", "192.0.2.60", true},
+		{"FOR", "192.0.2.60", true},
+		{"Proto", "https", true},
+		{"by", "", false},
+		{"host", "", false},
+		{"secret", "a b", true},
+		{"SECRET", "a b", true},
+		{"empty", "", true},
+		{"missing", "", false},
+	}
+	for _, c := range cases {
+		if got, ok := e.Get(c.key); got != c.want || ok != c.ok {
+			t.Errorf("Get(%q) = %q, %v, want: %q, %v", c.key, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestElementSet(t *testing.T) {
+	var e Element
+	e.Set("For", "192.0.2.60")
+	e.Set("proto", "http")
+	e.Set("PROTO", "https")
+	e.Set("by", "_proxy")
+	e.Set("host", "example.com")
+	e.Set("secret", "a")
+	e.Set("other", "b")
+	e.Set("Secret", "c")
+
+	want := Element{
+		By:    "_proxy",
+		For:   "192.0.2.60",
+		Proto: "https",
+		Host:  "example.com",
+		Extra: []Parameter{{"Secret", "c"}, {"other", "b"}},
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("Set() = %v, want: %v", e, want)
+	}
+
+	e = Element{Extra: []Parameter{{"a", "1"}, {"key", "2"}, {"b", "3"}, {"KEY", "4"}, {"c", "5"}}}
+	e.Set("Key", "6")
+	want = Element{Extra: []Parameter{{"a", "1"}, {"Key", "6"}, {"b", "3"}, {"c", "5"}}}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("Set(duplicate) = %v, want: %v", e, want)
+	}
+	if got, ok := e.Get("key"); got != "6" || !ok {
+		t.Errorf("Get(key) after Set = %q, %v, want: %q, true", got, ok, "6")
+	}
+}
+
+func TestElementParams(t *testing.T) {
+	e := Element{
+		By:    "203.0.113.43",
+		For:   "192.0.2.60",
+		Proto: "http",
+		Host:  "example.com",
+		Extra: []Parameter{
+			{"Key", "value"},
+			{"key", "other"},
+		},
+	}
+	want := map[string]string{
+		"by":    "203.0.113.43",
+		"for":   "192.0.2.60",
+		"proto": "http",
+		"host":  "example.com",
+		"key":   "other",
+	}
+	if got := e.Params(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Params() = %v, want: %v", got, want)
+	}
+
+	if got := (Element{}).Params(); len(got) != 0 {
+		t.Errorf("Element{}.Params() = %v, want empty map", got)
+	}
+}
+
+func TestElementHostPort(t *testing.T) {
+	cases := []struct {
+		in         string
+		host, port string
+		ok         bool
+	}{
+		{"example.com", "example.com", "", true},
+		{"example.com:8443", "example.com", "8443", true},
+		{"192.0.2.43:80", "192.0.2.43", "80", true},
+		{"[2001:db8::1]:443", "2001:db8::1", "443", true},
+		{"[2001:db8::1]", "2001:db8::1", "", true},
+		{"", "", "", false},
+		{"example.com:", "", "", false},
+		{"[::1]:", "", "", false},
+		{":443", "", "", false},
+		{"[]:443", "", "", false},
+		{"[2001:db8::1", "", "", false},
+		{"[2001:db8::1]443", "", "", false},
+		{"2001:db8::1", "", "", false},
+		{"example.com:http", "", "", false},
+	}
+
+	for _, c := range cases {
+		host, port, ok := Element{Host: c.in}.HostPort()
+		if host != c.host || port != c.port || ok != c.ok {
+			t.Errorf("Element{Host: %q}.HostPort() = (%q, %q, %v), want: (%q, %q, %v)", c.in, host, port, ok, c.host, c.port, c.ok)
+		}
+	}
+}
+
+func TestElementHostDecoded(t *testing.T) {
+	cases := []struct {
+		host string
+		want string
+		err  bool
+	}{
+		{"example.com", "example.com", false},
+		{"ex%61mple.com", "example.com", false},
+		{"example.com:8080", "example.com:8080", false},
+		{"ex%zzmple.com", "", true},
+	}
+
+	for _, c := range cases {
+		got, err := Element{Host: c.host}.HostDecoded()
+		if got != c.want || (err != nil) != c.err {
+			t.Errorf("Element{Host: %q}.HostDecoded() = (%q, %v), want: (%q, error %v)",
+				c.host, got, err, c.want, c.err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package forwarded
+
+import (
+	"net/http"
+	"net/netip"
+)
+
+// PortMode controls how the client port is written in the for
+// node of an element created by [NewElementForRequest].
+type PortMode int
+
+const (
+	// PortKeep writes the client port.
+	PortKeep PortMode = iota
+
+	// PortDrop omits the client port.
+	PortDrop
+
+	// PortObfuscate replaces the client port with an obfuscated
+	// port derived by the Obfuscator of the [HopOptions].
+	PortObfuscate
+)
+
+// HopOptions configures the element created for a request.
+type HopOptions struct {
+	// By is the by node of the element, typically the address
+	// the request was received on. It is omitted if empty.
+	By Node
+
+	// Port controls how the client port is written.
+	Port PortMode
+
+	// Obfuscator derives obfuscated ports for PortObfuscate.
+	// If nil or without a key, the client port is dropped
+	// instead.
+	Obfuscator *Obfuscator
+}
+
+// NewElementForRequest returns the element a proxy adds for
+// request r. The for node is the client address in r.RemoteAddr,
+// or "unknown" if it is not an IP address. The proto is "https"
+// for requests received over TLS and "http" otherwise. The host
+// is r.Host, if not empty. If opts is nil, the default options
+// are used.
+func NewElementForRequest(r *http.Request, opts *HopOptions) *Element {
+	if opts == nil {
+		opts = &HopOptions{}
+	}
+
+	e := &Element{
+		By:    opts.By,
+		For:   "unknown",
+		Proto: "http",
+		Host:  r.Host,
+	}
+	if r.TLS != nil {
+		e.Proto = "https"
+	}
+
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return e
+	}
+	switch opts.Port {
+	case PortKeep:
+		e.For = NodeFromAddrPort(ap)
+	case PortObfuscate:
+		e.For = NodeFromAddr(ap.Addr())
+		if p := opts.Obfuscator; p != nil && len(p.Key) > 0 {
+			e.For += ":" + Node(p.ObfuscatePort(ap.Port()))
+		}
+	default:
+		e.For = NodeFromAddr(ap.Addr())
+	}
+	return e
+}
+
+// AppendHop appends the element returned by [NewElementForRequest]
+// to the Forwarded header of request r using [AppendRequest].
+func AppendHop(r *http.Request, opts *HopOptions) {
+	AppendRequest(r, NewElementForRequest(r, opts))
+}
@@ -0,0 +1,100 @@
+package forwarded
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestNewElementForRequest(t *testing.T) {
+	obf := &Obfuscator{Key: []byte("secret")}
+
+	cases := []struct {
+		name   string
+		remote string
+		tls    bool
+		opts   *HopOptions
+		want   Element
+	}{
+		{
+			name:   "default",
+			remote: "192.0.2.43:47011",
+			want:   Element{For: "192.0.2.43:47011", Proto: "http", Host: "example.com"},
+		},
+		{
+			name:   "keep/ipv6",
+			remote: "[2001:db8:cafe::17]:47011",
+			tls:    true,
+			opts:   &HopOptions{By: "_proxy"},
+			want:   Element{By: "_proxy", For: "[2001:db8:cafe::17]:47011", Proto: "https", Host: "example.com"},
+		},
+		{
+			name:   "drop",
+			remote: "192.0.2.43:47011",
+			opts:   &HopOptions{Port: PortDrop},
+			want:   Element{For: "192.0.2.43", Proto: "http", Host: "example.com"},
+		},
+		{
+			name:   "drop/ipv6",
+			remote: "[2001:db8:cafe::17]:47011",
+			opts:   &HopOptions{Port: PortDrop},
+			want:   Element{For: "[2001:db8:cafe::17]", Proto: "http", Host: "example.com"},
+		},
+		{
+			name:   "obfuscate",
+			remote: "192.0.2.43:47011",
+			opts:   &HopOptions{Port: PortObfuscate, Obfuscator: obf},
+			want:   Element{For: "192.0.2.43:" + Node(obf.ObfuscatePort(47011)), Proto: "http", Host: "example.com"},
+		},
+		{
+			name:   "obfuscate/no-obfuscator",
+			remote: "192.0.2.43:47011",
+			opts:   &HopOptions{Port: PortObfuscate},
+			want:   Element{For: "192.0.2.43", Proto: "http", Host: "example.com"},
+		},
+		{
+			name:   "obfuscate/empty-key",
+			remote: "192.0.2.43:47011",
+			opts:   &HopOptions{Port: PortObfuscate, Obfuscator: &Obfuscator{}},
+			want:   Element{For: "192.0.2.43", Proto: "http", Host: "example.com"},
+		},
+		{
+			name:   "unknown",
+			remote: "@",
+			want:   Element{For: "unknown", Proto: "http", Host: "example.com"},
+		},
+	}
+
+	for _, c := range cases {
+		r := &http.Request{RemoteAddr: c.remote, Host: "example.com"}
+		if c.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+
+		got := NewElementForRequest(r, c.opts)
+		if got.String() != c.want.String() {
+			t.Errorf("%s: NewElementForRequest() = %q, want: %q", c.name, got, c.want)
+		}
+
+		if c.opts != nil && c.opts.Port != PortKeep {
+			addr, port, _ := got.For.AddrPort()
+			if _, numeric := port.Uint16(); !addr.IsValid() || numeric {
+				t.Errorf("%s: for node %q has numeric port", c.name, got.For)
+			}
+		}
+	}
+}
+
+func TestAppendHop(t *testing.T) {
+	r := &http.Request{
+		RemoteAddr: "192.0.2.43:47011",
+		Host:       "example.com",
+		Header:     http.Header{"Forwarded": {"for=198.51.100.17"}},
+	}
+	AppendHop(r, &HopOptions{Port: PortDrop})
+
+	want := "for=198.51.100.17, for=192.0.2.43;proto=http;host=example.com"
+	if got := r.Header.Get("Forwarded"); got != want {
+		t.Errorf("Forwarded = %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package forwarded
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// MarshalJSON implements [json.Marshaler]. Element e is encoded
+// as an object with the keys "by", "for", "proto" and "host"
+// followed by the extra parameters in order. Empty well-known
+// parameters are omitted.
+func (e Element) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	add := func(key, value string) {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, _ := json.Marshal(value)
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	if e.By != "" {
+		add("by", string(e.By))
+	}
+	if e.For != "" {
+		add("for", string(e.For))
+	}
+	if e.Proto != "" {
+		add("proto", e.Proto)
+	}
+	if e.Host != "" {
+		add("host", e.Host)
+	}
+	for _, p := range e.Extra {
+		add(p.Key, p.Value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// UnmarshalJSON implements [json.Unmarshaler]. It decodes an
+// object as encoded by [Element.MarshalJSON]. The well-known keys
+// are matched case-insensitively, other keys are added to Extra
+// in order. All values must be strings. Like for other types,
+// a JSON null leaves element e unchanged.
+func (e *Element) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t == nil {
+		return nil
+	} else if t != json.Delim('{') {
+		return errors.New("forwarded: element is not a JSON object")
+	}
+
+	var elem Element
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := t.(string)
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		switch strings.ToLower(key) {
+		case "by":
+			elem.By = Node(value)
+		case "for":
+			elem.For = Node(value)
+		case "proto":
+			elem.Proto = value
+		case "host":
+			elem.Host = value
+		default:
+			elem.Extra = append(elem.Extra, Parameter{key, value})
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	*e = elem
+	return nil
+}
@@ -0,0 +1,82 @@
+package forwarded
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestElementJSON(t *testing.T) {
+	e := &Element{
+		By:    "_proxy",
+		For:   "[2001:db8:cafe::17]:4711",
+		Proto: "https",
+		Extra: []Parameter{{"Key", `a "b"`}, {"other", "c"}},
+	}
+	want := `{"by":"_proxy","for":"[2001:db8:cafe::17]:4711","proto":"https","Key":"a \"b\"","other":"c"}`
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != want {
+		t.Errorf("Marshal() = %s, want: %s", b, want)
+	}
+
+	var got Element
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, e) {
+		t.Errorf("Unmarshal() = %v, want: %v", got, e)
+	}
+
+	if b, _ := json.Marshal(Element{}); string(b) != "{}" {
+		t.Errorf("Marshal(empty) = %s, want: {}", b)
+	}
+
+	for _, c := range parseTests {
+		for _, want := range c.want {
+			b, err := json.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got Element
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("%s: Unmarshal(%s) error: %v", c.name, b, err)
+			}
+			if !reflect.DeepEqual(&got, want) {
+				t.Errorf("%s: round-trip = %v, want: %v", c.name, got, want)
+			}
+		}
+	}
+
+	got = Element{For: "unknown"}
+	for _, in := range []string{`[]`, `{"for":1}`, `{"for":"a"`, `"for=a"`} {
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("Unmarshal(%s) error = nil", in)
+		}
+		if got.For != "unknown" {
+			t.Errorf("Unmarshal(%s) modified element on error: %v", in, got)
+		}
+	}
+}
+
+func TestElementUnmarshalJSONNull(t *testing.T) {
+	var v struct {
+		E Element
+		P *Element
+	}
+	v.E = Element{For: "192.0.2.43"}
+	if err := json.Unmarshal([]byte(`{"E":null,"P":null}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Element{For: "192.0.2.43"}); !reflect.DeepEqual(v.E, want) || v.P != nil {
+		t.Errorf("Unmarshal(null) = %v, %v, want: %v, nil", v.E, v.P, want)
+	}
+
+	var e Element
+	if err := json.Unmarshal([]byte(`"for=192.0.2.43"`), &e); err == nil {
+		t.Error("Unmarshal(string) error = nil, want error")
+	}
+}
@@ -0,0 +1,21 @@
+package forwarded
+
+import "iter"
+
+// ParseLenient is like [Parse], but tolerates malformed input
+// commonly sent by proxies. It:
+//   - skips empty elements, such as caused by stray commas,
+//     also when combined with strict parsing in a [Parser];
+//   - skips empty parameters, such as caused by stray semicolons;
+//   - trims Unicode whitespace instead of only spaces and tabs
+//     around elements, parameters and "=".
+//
+// The error returned is of type [*ParseError].
+func ParseLenient(line string, reverse bool) iter.Seq2[*Element, error] {
+	return parse(line, reverse, &lenientOptions)
+}
+
+var lenientOptions = options{
+	skipEmpty: true,
+	trimSpace: true,
+}
@@ -0,0 +1,52 @@
+package forwarded
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParseLenient(t *testing.T) {
+	for _, c := range parseTests {
+		t.Run(c.name, func(t *testing.T) {
+			t.Run("forward", testParseLenient(c.in, c.want, false))
+			t.Run("reverse", testParseLenient(c.in, c.want, true))
+		})
+	}
+
+	messy := ", for=192.0.2.43,, for = 198.51.100.17 ;;\u00a0proto= https ; , "
+	want := []*Element{
+		{For: "192.0.2.43"},
+		{For: "198.51.100.17", Proto: "https"},
+	}
+	t.Run("messy", func(t *testing.T) {
+		for _, err := range ParseStrict(messy, false) {
+			if err != nil {
+				return
+			}
+		}
+		t.Errorf("ParseStrict(%q) got no error", messy)
+	})
+	t.Run("messy/forward", testParseLenient(messy, want, false))
+	t.Run("messy/reverse", testParseLenient(messy, want, true))
+}
+
+func testParseLenient(in string, want []*Element, reverse bool) func(t *testing.T) {
+	return func(t *testing.T) {
+		var got []*Element
+		for elem, err := range ParseLenient(in, reverse) {
+			if err != nil {
+				t.Fatalf("got error: %v\nelems: %v", err, got)
+			}
+			got = append(got, elem)
+		}
+
+		if reverse {
+			want = slices.Clone(want)
+			slices.Reverse(want)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("\ngot:  %v\nwant: %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package forwarded
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"slices"
+	"strconv"
+)
+
+// obfuscatedSize is the number of HMAC bytes used for
+// an obfuscated identifier.
+const obfuscatedSize = 8
+
+// Obfuscator maps nodes to obfuscated identifiers as described
+// in RFC 7239, section 6.3. A node is always mapped to the same
+// identifier for a given key, so a gateway can emit consistent
+// obfuscated chains. Identifiers are derived on every call and
+// not cached, as the nodes are often supplied by clients, so a
+// cache could be made to grow without limit. It is safe for
+// concurrent use.
+//
+// The zero value is not secure: without a key anyone can compute
+// the identifiers and recover addresses by trying all of them.
+// Therefore an Obfuscator with an empty key maps every node to
+// "unknown" and every port to an empty port.
+type Obfuscator struct {
+	// Key is the secret used to derive identifiers. It must not
+	// be empty and not be changed after first use.
+	Key []byte
+}
+
+// ErrEmptyKey is returned by [NewObfuscator] for an empty secret.
+var ErrEmptyKey = errors.New("forwarded: empty obfuscator key")
+
+// NewObfuscator returns an Obfuscator that derives identifiers
+// from the given secret. The secret is copied. It returns
+// [ErrEmptyKey] if the secret is empty.
+func NewObfuscator(secret []byte) (*Obfuscator, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptyKey
+	}
+	return &Obfuscator{Key: slices.Clone(secret)}, nil
+}
+
+// Node returns the obfuscated identifier for node n.
+// Empty, unknown and already obfuscated nodes are returned
+// unchanged. Without a key other nodes are "unknown".
+func (o *Obfuscator) Node(n Node) Node {
+	if n == "" || n.IsUnknown() || n.IsObfuscated() {
+		return n
+	}
+	if len(o.Key) == 0 {
+		return "unknown"
+	}
+	return Node(o.token(string(n)))
+}
+
+// Obfuscate returns the obfuscated identifier for address addr,
+// which is the same as for the node of addr without port. It
+// returns "unknown" if addr is invalid.
+func (o *Obfuscator) Obfuscate(addr netip.Addr) Node {
+	if !addr.IsValid() {
+		return "unknown"
+	}
+	return o.Node(NodeFromAddr(addr))
+}
+
+// ObfuscatePort returns the obfuscated node port for port p,
+// or an empty port without a key.
+func (o *Obfuscator) ObfuscatePort(p uint16) NodePort {
+	if len(o.Key) == 0 {
+		return ""
+	}
+	return NodePort(o.token("port:" + strconv.FormatUint(uint64(p), 10)))
+}
+
+// token returns an underscore followed by the truncated
+// HMAC-SHA256 of s in hexadecimal, which is a valid
+// obfuscated identifier. The key must not be empty.
+func (o *Obfuscator) token(s string) string {
+	mac := hmac.New(sha256.New, o.Key)
+	mac.Write([]byte(s))
+	sum := mac.Sum(nil)
+	return "_" + hex.EncodeToString(sum[:obfuscatedSize])
+}
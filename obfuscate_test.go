@@ -0,0 +1,98 @@
+package forwarded
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestObfuscatorNode(t *testing.T) {
+	obf := &Obfuscator{Key: []byte("secret")}
+
+	a := obf.Node("192.0.2.43")
+	if !a.IsObfuscated() {
+		t.Errorf("Node(%q) = %q, want obfuscated node", "192.0.2.43", a)
+	}
+	if again := obf.Node("192.0.2.43"); again != a {
+		t.Errorf("Node(%q) = %q, want: %q", "192.0.2.43", again, a)
+	}
+	if fresh := (&Obfuscator{Key: []byte("secret")}).Node("192.0.2.43"); fresh != a {
+		t.Errorf("Node(%q) with same key = %q, want: %q", "192.0.2.43", fresh, a)
+	}
+	if b := obf.Node("198.51.100.17"); b == a {
+		t.Errorf("Node(%q) = %q, same as for %q", "198.51.100.17", b, "192.0.2.43")
+	}
+	if other := (&Obfuscator{Key: []byte("other")}).Node("192.0.2.43"); other == a {
+		t.Errorf("Node(%q) with other key = %q, want different token", "192.0.2.43", other)
+	}
+
+	for _, n := range []Node{"", "unknown", "_gazonk"} {
+		if got := obf.Node(n); got != n {
+			t.Errorf("Node(%q) = %q, want unchanged", n, got)
+		}
+	}
+}
+
+func TestObfuscatorObfuscate(t *testing.T) {
+	secret := []byte("secret")
+	obf, err := NewObfuscator(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret[0] = 'S'
+
+	addr := netip.MustParseAddr("192.0.2.43")
+	a := obf.Obfuscate(addr)
+	if !validObfuscated(string(a)) {
+		t.Errorf("Obfuscate(%v) = %q, want valid obfuscated node", addr, a)
+	}
+	if want := (&Obfuscator{Key: []byte("secret")}).Node("192.0.2.43"); a != want {
+		t.Errorf("Obfuscate(%v) = %q, want: %q", addr, a, want)
+	}
+	if mapped := obf.Obfuscate(netip.MustParseAddr("::ffff:192.0.2.43")); mapped != a {
+		t.Errorf("Obfuscate(mapped) = %q, want: %q", mapped, a)
+	}
+
+	v6 := netip.MustParseAddr("2001:db8:cafe::17")
+	if got, want := obf.Obfuscate(v6), obf.Node("[2001:db8:cafe::17]"); got != want {
+		t.Errorf("Obfuscate(%v) = %q, want: %q", v6, got, want)
+	}
+	if got := obf.Obfuscate(netip.Addr{}); got != "unknown" {
+		t.Errorf("Obfuscate(invalid) = %q, want: unknown", got)
+	}
+
+	p := obf.ObfuscatePort(443)
+	if !p.IsValidObfuscated() {
+		t.Errorf("ObfuscatePort(443) = %q, want valid obfuscated port", p)
+	}
+	if again := obf.ObfuscatePort(443); again != p {
+		t.Errorf("ObfuscatePort(443) = %q, want: %q", again, p)
+	}
+	if other := obf.ObfuscatePort(80); other == p {
+		t.Errorf("ObfuscatePort(80) = %q, same as for 443", other)
+	}
+}
+
+func TestObfuscatorEmptyKey(t *testing.T) {
+	for _, secret := range [][]byte{nil, {}} {
+		if obf, err := NewObfuscator(secret); obf != nil || !errors.Is(err, ErrEmptyKey) {
+			t.Errorf("NewObfuscator(%q) = %v, %v, want: %v", secret, obf, err, ErrEmptyKey)
+		}
+	}
+
+	var zero Obfuscator
+	for _, n := range []Node{"192.0.2.43", "unknown"} {
+		if got := zero.Node(n); got != "unknown" {
+			t.Errorf("zero value Node(%q) = %q, want: unknown", n, got)
+		}
+	}
+	if got := zero.Node("_gazonk"); got != "_gazonk" {
+		t.Errorf("zero value Node(_gazonk) = %q, want unchanged", got)
+	}
+	if got := zero.Obfuscate(netip.MustParseAddr("192.0.2.43")); got != "unknown" {
+		t.Errorf("zero value Obfuscate() = %q, want: unknown", got)
+	}
+	if got := zero.ObfuscatePort(443); got != "" {
+		t.Errorf("zero value ObfuscatePort(443) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,218 @@
+package forwarded
+
+import "strings"
+
+// options configures the checks and tolerances used while parsing.
+// A nil *options parses as permissive as possible.
+type options struct {
+	// duplicates rejects repeated well-known parameters.
+	duplicates bool
+	// obfuscated rejects obfuscated identifiers with
+	// characters outside the allowed charset.
+	obfuscated bool
+	// nodes rejects nodes that are not an IP address,
+	// "unknown" or an obfuscated identifier.
+	nodes bool
+	// ports rejects numeric node ports out of range
+	// or with leading zeros.
+	ports bool
+	// proto rejects proto values that are not a scheme.
+	proto bool
+	// lowerProto lowercases proto values.
+	lowerProto bool
+	// host rejects invalid host values.
+	host bool
+	// obsText rejects values containing obs-text.
+	obsText bool
+
+	// skipEmpty skips empty parameters.
+	skipEmpty bool
+	// keepEmpty rejects empty elements instead of skipping them.
+	keepEmpty bool
+	// keepEquals does not trim whitespace around "=", so a
+	// token or value next to it is rejected.
+	keepEquals bool
+	// trimSpace trims Unicode whitespace instead of OWS.
+	trimSpace bool
+
+	// maxElements limits the number of elements, if not zero.
+	maxElements int
+	// maxKeyLength limits the length of a key, if not zero.
+	maxKeyLength int
+	// maxValueLength limits the length of a value, if not zero.
+	maxValueLength int
+	// interner deduplicates values, if not nil.
+	interner *Parser
+	// clone copies values, so they do not refer to the line.
+	clone bool
+	// pool provides elements, if not nil.
+	pool *Parser
+	// share reuses the previous element for the next one.
+	share bool
+}
+
+var strictOptions = options{
+	duplicates: true,
+	keepEmpty:  true,
+	keepEquals: true,
+	obfuscated: true,
+	nodes:      true,
+	ports:      true,
+	proto:      true,
+	lowerProto: true,
+	host:       true,
+	obsText:    true,
+}
+
+// trim trims whitespace from s according to options o.
+func (o *options) trim(s string) string {
+	if o != nil && o.trimSpace {
+		return strings.TrimSpace(s)
+	}
+	return trimOWS(s)
+}
+
+// skip reports whether trimmed parameter s is skipped
+// according to options o.
+func (o *options) skip(s string) bool {
+	return o != nil && o.skipEmpty && s == ""
+}
+
+// skipElement reports whether trimmed element s is skipped
+// according to options o.
+func (o *options) skipElement(s string) bool {
+	return s == "" && (o == nil || !o.keepEmpty)
+}
+
+// checkElements checks whether the n-th element may be
+// parsed according to options o.
+func (o *options) checkElements(n int, elem string) error {
+	if o == nil || o.maxElements <= 0 || n <= o.maxElements {
+		return nil
+	}
+	return &ParseError{Msg: `too many elements`, Text: elem, err: ErrTooManyElements}
+}
+
+// checkKeyLength checks the length of parameter name token
+// according to options o. The error text is truncated to the
+// maximum length.
+func (o *options) checkKeyLength(token string) error {
+	if o == nil || o.maxKeyLength <= 0 || len(token) <= o.maxKeyLength {
+		return nil
+	}
+	return &ParseError{Msg: `parameter name too long`, Text: token[:o.maxKeyLength], err: ErrTooLong}
+}
+
+// checkValueLength checks the length of raw value v of
+// parameter token according to options o.
+func (o *options) checkValueLength(token, v string) error {
+	if o == nil || o.maxValueLength <= 0 || len(v) <= o.maxValueLength {
+		return nil
+	}
+	return &ParseError{Msg: `value too long for`, Text: token, err: ErrTooLong}
+}
+
+// intern returns the interned value of s according to options o.
+func (o *options) intern(s string) string {
+	switch {
+	case o == nil:
+		return s
+	case o.interner != nil:
+		return o.interner.intern(s)
+	case o.clone:
+		return strings.Clone(s)
+	}
+	return s
+}
+
+// sharedOptions are the options used by [ParseShared].
+var sharedOptions = options{share: true}
+
+// element returns an element to parse into according to options o.
+// Element prev is the previous element returned, if any.
+func (o *options) element(prev *Element) *Element {
+	if o != nil && o.share && prev != nil {
+		*prev = Element{Extra: prev.Extra[:0]}
+		return prev
+	}
+	if o == nil || o.pool == nil {
+		return new(Element)
+	}
+	return o.pool.element()
+}
+
+// release releases element e according to options o.
+func (o *options) release(e *Element) {
+	if o != nil && o.pool != nil {
+		o.pool.release(e)
+	}
+}
+
+// checkValue checks unescaped value v according to options o.
+func (o *options) checkValue(v string) error {
+	if o == nil {
+		return nil
+	}
+	if o.obsText {
+		for i := 0; i < len(v); i++ {
+			if v[i] >= 0x80 {
+				return &ParseError{Msg: `obs-text found in`, Text: v, err: ErrInvalidValue}
+			}
+		}
+	}
+	return nil
+}
+
+// checkDuplicate checks whether well-known parameter key may
+// be set according to options o. Parameter seen indicates
+// if the parameter has been set before.
+func (o *options) checkDuplicate(key string, seen bool) error {
+	if o == nil || !o.duplicates || !seen {
+		return nil
+	}
+	return &ParseError{Msg: `duplicate parameter`, Text: key, err: ErrDuplicateParameter}
+}
+
+// checkNode checks node n according to options o.
+func (o *options) checkNode(n Node) error {
+	if o == nil || !o.obfuscated && !o.nodes && !o.ports {
+		return nil
+	}
+	name, port := n.split()
+	if o.obfuscated {
+		if strings.HasPrefix(name, "_") && !validObfuscated(name) {
+			return &ParseError{Msg: `invalid obfuscated node`, Text: name, err: ErrInvalidValue}
+		}
+		if port.IsObfuscated() && !port.IsValidObfuscated() {
+			return &ParseError{Msg: `invalid obfuscated port`, Text: string(port), err: ErrInvalidValue}
+		}
+	}
+	if o.ports && isDigits(string(port)) {
+		if !validPort(string(port)) {
+			return &ParseError{Msg: `invalid port`, Text: string(port), err: ErrInvalidValue}
+		}
+		if len(port) > 1 && port[0] == '0' {
+			return &ParseError{Msg: `leading zero in port`, Text: string(port), err: ErrInvalidValue}
+		}
+	}
+	if o.nodes && !validNode(n) {
+		return &ParseError{Msg: `invalid node`, Text: string(n), err: ErrInvalidValue}
+	}
+	return nil
+}
+
+// checkProto checks proto value p according to options o.
+func (o *options) checkProto(p string) error {
+	if o == nil || !o.proto || validScheme(p) {
+		return nil
+	}
+	return &ParseError{Msg: `invalid proto`, Text: p, err: ErrInvalidValue}
+}
+
+// checkHost checks host value h according to options o.
+func (o *options) checkHost(h string) error {
+	if o == nil || !o.host || validHost(h) {
+		return nil
+	}
+	return &ParseError{Msg: `invalid host`, Text: h, err: ErrInvalidValue}
+}
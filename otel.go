@@ -0,0 +1,51 @@
+package forwarded
+
+import "strconv"
+
+// OTelAttributes returns the parameters of element e as
+// OpenTelemetry attributes following the semantic conventions,
+// without depending on the OpenTelemetry API. The mapping is:
+//   - for: client.address and client.port;
+//   - by: network.local.address and network.local.port;
+//   - proto: url.scheme;
+//   - host: server.address and server.port.
+//
+// Addresses are formatted without square brackets. Ports are only
+// included when numeric. An obfuscated by or for node is used as
+// address as is, like "_gazonk", as it identifies the node without
+// revealing it. The address of an "unknown" node is omitted, like
+// empty parameters and extra parameters.
+func (e Element) OTelAttributes() map[string]string {
+	attrs := make(map[string]string)
+	add := func(addrKey, portKey, name string, port NodePort) {
+		if name != "" {
+			attrs[addrKey] = name
+		}
+		if p, ok := port.Uint16(); ok {
+			attrs[portKey] = strconv.FormatUint(uint64(p), 10)
+		}
+	}
+
+	addNode := func(addrKey, portKey string, n Node) {
+		name, port := n.split()
+		if Node(name).IsUnknown() {
+			name = ""
+		}
+		add(addrKey, portKey, name, port)
+	}
+
+	if e.For != "" {
+		addNode("client.address", "client.port", e.For)
+	}
+	if e.By != "" {
+		addNode("network.local.address", "network.local.port", e.By)
+	}
+	if e.Proto != "" {
+		attrs["url.scheme"] = e.Proto
+	}
+	if e.Host != "" {
+		name, port := Node(e.Host).split()
+		add("server.address", "server.port", name, port)
+	}
+	return attrs
+}
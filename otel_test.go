@@ -0,0 +1,45 @@
+package forwarded
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestElementOTelAttributes(t *testing.T) {
+	e := Element{
+		For:   "[2001:db8:cafe::17]:04711",
+		By:    "_gazonk:_port",
+		Proto: "https",
+		Host:  "example.com:8443",
+		Extra: []Parameter{{"key", "value"}},
+	}
+	want := map[string]string{
+		"client.address":        "2001:db8:cafe::17",
+		"client.port":           "4711",
+		"network.local.address": "_gazonk",
+		"url.scheme":            "https",
+		"server.address":        "example.com",
+		"server.port":           "8443",
+	}
+	if got := e.OTelAttributes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("OTelAttributes() = %v, want: %v", got, want)
+	}
+
+	e = Element{For: "192.0.2.43", Host: "[2001:db8::1]"}
+	want = map[string]string{
+		"client.address": "192.0.2.43",
+		"server.address": "2001:db8::1",
+	}
+	if got := e.OTelAttributes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("OTelAttributes() = %v, want: %v", got, want)
+	}
+
+	e = Element{For: "unknown", By: "unknown:8080", Host: "unknown"}
+	want = map[string]string{
+		"network.local.port": "8080",
+		"server.address":     "unknown",
+	}
+	if got := e.OTelAttributes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("OTelAttributes() = %v, want: %v", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+package forwarded
+
+import (
+	"iter"
+	"strings"
+	"sync"
+)
+
+// Parser parses lines using a configuration. The zero value
+// parses like [Parse]. A Parser must not be modified after
+// first use, but it is safe for concurrent use.
+type Parser struct {
+	// MaxElements limits the number of elements in a line.
+	// If exceeded, a [*ParseError] is returned. Zero means
+	// no limit.
+	MaxElements int
+
+	// MaxKeyLength limits the length of a parameter name.
+	// If exceeded, a [*ParseError] is returned. Zero means
+	// no limit.
+	MaxKeyLength int
+
+	// MaxValueLength limits the length of a parameter value
+	// as it appears in a line, including quotes. If exceeded,
+	// a [*ParseError] is returned. Zero means no limit.
+	MaxValueLength int
+
+	// Strict enables the checks done by [ParseStrict].
+	Strict bool
+
+	// Lenient enables the tolerances of [ParseLenient].
+	Lenient bool
+
+	// Intern enables deduplication of parameter values and
+	// extra parameter names across lines parsed by the Parser.
+	// Interned strings do not retain the line they are parsed
+	// from. The Parser keeps at most 4096 distinct strings and
+	// starts over once that many are kept, so memory stays
+	// bounded for untrusted input.
+	Intern bool
+
+	// Pool enables reuse of elements and chains. Elements
+	// parsed by the Parser can be returned with [Parser.Release]
+	// once they are no longer used, which reduces allocations
+	// when parsing many lines. After release, the elements and
+	// the chain must not be used anymore, including values
+	// derived from them like Extra.
+	Pool bool
+
+	mu       sync.Mutex
+	interned map[string]string
+	elems    sync.Pool // of *Element
+	chains   sync.Pool // of *Chain
+}
+
+// maxInterned is the number of distinct strings a Parser
+// keeps before it clears them.
+const maxInterned = 4096
+
+// Parse parses elements in the given line. If reverse
+// is true, the elements are parsed in reverse.
+// The error returned is of type [*ParseError].
+func (p *Parser) Parse(line string, reverse bool) iter.Seq2[*Element, error] {
+	return parse(line, reverse, p.options())
+}
+
+// ParseChain parses all elements in the given line into a
+// chain. The error returned is of type [*ParseError]. If Pool
+// is enabled, the chain should be returned with [Parser.Release].
+func (p *Parser) ParseChain(line string) (Chain, error) {
+	var c Chain
+	if p.Pool {
+		if v, ok := p.chains.Get().(*Chain); ok {
+			c = *v
+		}
+	}
+	for e, err := range p.Parse(line, false) {
+		if err != nil {
+			p.Release(c)
+			return nil, err
+		}
+		c = append(c, e)
+	}
+	return c, nil
+}
+
+// Release returns the elements of chain c and the chain
+// itself to the pool of parser p. It does nothing if Pool
+// is not enabled. Chain c and its elements must not be
+// used after release.
+func (p *Parser) Release(c Chain) {
+	if !p.Pool {
+		return
+	}
+	for _, e := range c {
+		p.release(e)
+	}
+	clear(c)
+	c = c[:0]
+	p.chains.Put(&c)
+}
+
+// options returns the parse options for parser p.
+func (p *Parser) options() *options {
+	var o options
+	if p.Strict {
+		o = strictOptions
+	}
+	if p.Lenient {
+		o.skipEmpty = lenientOptions.skipEmpty
+		o.keepEmpty = lenientOptions.keepEmpty
+		o.keepEquals = lenientOptions.keepEquals
+		o.trimSpace = lenientOptions.trimSpace
+	}
+	o.maxElements = p.MaxElements
+	o.maxKeyLength = p.MaxKeyLength
+	o.maxValueLength = p.MaxValueLength
+	if p.Intern {
+		o.interner = p
+	}
+	if p.Pool {
+		o.pool = p
+	}
+	return &o
+}
+
+// intern returns the interned copy of string s.
+func (p *Parser) intern(s string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if v, ok := p.interned[s]; ok {
+		return v
+	}
+	if p.interned == nil {
+		p.interned = make(map[string]string)
+	} else if len(p.interned) >= maxInterned {
+		clear(p.interned)
+	}
+	s = strings.Clone(s)
+	p.interned[s] = s
+	return s
+}
+
+// element returns an element from the pool.
+func (p *Parser) element() *Element {
+	if e, ok := p.elems.Get().(*Element); ok {
+		return e
+	}
+	return new(Element)
+}
+
+// release resets element e and puts it in the pool.
+// The capacity of its extra parameters is kept.
+func (p *Parser) release(e *Element) {
+	if e == nil {
+		return
+	}
+	clear(e.Extra)
+	*e = Element{Extra: e.Extra[:0]}
+	p.elems.Put(e)
+}
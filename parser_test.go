@@ -0,0 +1,187 @@
+package forwarded
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestParser(t *testing.T) {
+	var zero Parser
+	for _, c := range parseTests {
+		var got []*Element
+		for elem, err := range zero.Parse(c.in, false) {
+			if err != nil {
+				t.Fatalf("%s: got error: %v", c.name, err)
+			}
+			got = append(got, elem)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\ngot:  %v\nwant: %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParserLimits(t *testing.T) {
+	p := &Parser{MaxElements: 2, MaxValueLength: 16}
+
+	cases := []struct {
+		in  string
+		msg string
+	}{
+		{"for=192.0.2.43", ""},
+		{"for=192.0.2.43, for=198.51.100.17", ""},
+		{"for=192.0.2.43, for=198.51.100.17, for=unknown", "too many elements"},
+		{`for="[2001:db8:cafe::17]"`, "value too long for"},
+		{"for=192.0.2.43", ""},
+	}
+
+	for _, c := range cases {
+		for _, reverse := range []bool{false, true} {
+			var msg string
+			n := 0
+			for _, err := range p.Parse(c.in, reverse) {
+				if err != nil {
+					msg = err.(*ParseError).Msg
+					break
+				}
+				n++
+			}
+			if msg != c.msg {
+				t.Errorf("Parse(%q, %v) error = %q, want: %q", c.in, reverse, msg, c.msg)
+			}
+			if c.msg == "too many elements" && n != p.MaxElements {
+				t.Errorf("Parse(%q, %v) yielded %d elements, want: %d", c.in, reverse, n, p.MaxElements)
+			}
+		}
+	}
+}
+
+func TestParserMaxKeyLength(t *testing.T) {
+	p := &Parser{MaxKeyLength: 8}
+
+	for _, err := range p.Parse("for=192.0.2.43;tracekey=a", false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	in := "for=192.0.2.43;" + strings.Repeat("k", 1<<16) + "=v"
+	for _, err := range p.Parse(in, false) {
+		pe, ok := err.(*ParseError)
+		if !ok || pe.Msg != "parameter name too long" || len(pe.Text) != p.MaxKeyLength {
+			t.Errorf("Parse(long key) error = %v, want parameter name too long", err)
+		}
+		break
+	}
+}
+
+func TestParserStrictLenient(t *testing.T) {
+	in := "for=192.0.2.43;;for=198.51.100.17"
+	cases := []struct {
+		p   *Parser
+		err bool
+	}{
+		{&Parser{}, true},
+		{&Parser{Lenient: true}, false},
+		{&Parser{Strict: true, Lenient: true}, true},
+	}
+
+	for _, c := range cases {
+		var err error
+		for _, err = range c.p.Parse(in, false) {
+			if err != nil {
+				break
+			}
+		}
+		if (err != nil) != c.err {
+			t.Errorf("Parser{Strict: %v, Lenient: %v}.Parse(%q) error = %v, want error: %v",
+				c.p.Strict, c.p.Lenient, in, err, c.err)
+		}
+	}
+}
+
+func TestParserIntern(t *testing.T) {
+	p := &Parser{Intern: true}
+
+	var protos []string
+	for _, line := range []string{"proto=https", "for=192.0.2.43;proto=https"} {
+		for elem, err := range p.Parse(line, false) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			protos = append(protos, elem.Proto)
+		}
+	}
+
+	if unsafe.StringData(protos[0]) != unsafe.StringData(protos[1]) {
+		t.Error("proto values of different lines are not interned")
+	}
+}
+
+func TestParserInternBounded(t *testing.T) {
+	p := &Parser{Intern: true}
+	for i := range 3 * maxInterned {
+		line := "proto=p" + strconv.Itoa(i)
+		for _, err := range p.Parse(line, false) {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if n := len(p.interned); n > maxInterned {
+		t.Errorf("Parser keeps %d interned strings, want at most %d", n, maxInterned)
+	}
+}
+
+func TestParserPool(t *testing.T) {
+	p := &Parser{Pool: true}
+
+	for _, c := range parseTests {
+		for range 2 {
+			got, err := p.ParseChain(c.in)
+			if err != nil {
+				t.Fatalf("%s: got error: %v", c.name, err)
+			}
+			if !reflect.DeepEqual([]*Element(got), c.want) {
+				t.Errorf("%s:\ngot:  %v\nwant: %v", c.name, got, c.want)
+			}
+			p.Release(got)
+		}
+	}
+
+	if _, err := p.ParseChain("for=192.0.2.43;a=b, for"); err == nil {
+		t.Error("ParseChain(invalid) error = nil")
+	}
+	got, err := p.ParseChain("for=192.0.2.43")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Chain{{For: "192.0.2.43"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseChain after error = %v, want: %v", got, want)
+	}
+}
+
+func BenchmarkParserParseChain(b *testing.B) {
+	line := `for=192.0.2.43;a=b, for="[2001:db8:cafe::17]:4711";by=203.0.113.60;proto=https;host=example.com`
+
+	for _, pool := range []bool{false, true} {
+		name := "plain"
+		if pool {
+			name = "pool"
+		}
+		b.Run(name, func(b *testing.B) {
+			p := &Parser{Pool: pool}
+			b.ReportAllocs()
+			for b.Loop() {
+				c, err := p.ParseChain(line)
+				if err != nil {
+					b.Fatal(err)
+				}
+				p.Release(c)
+			}
+		})
+	}
+}
@@ -2,31 +2,93 @@ package forwarded
 
 import (
 	"errors"
+	"iter"
+	"slices"
 	"strings"
 )
 
+// EscapeValue returns value s as token or quoted-string, as it
+// is written in a parameter.
+func EscapeValue(s string) string {
+	return escape(s)
+}
+
+// UnescapeValue returns the value of token or quoted-string s,
+// as it is read from a parameter.
+func UnescapeValue(s string) (string, error) {
+	return unescape(s)
+}
+
+// TrimOWS returns s without the optional whitespace, spaces
+// and tabs, around it, as defined by RFC 7230, section 3.2.3.
+func TrimOWS(s string) string {
+	return trimOWS(s)
+}
+
+// ValidToken reports whether s is a token per RFC 7230,
+// section 3.2.6: a non-empty string of tchar. Parameter
+// names in [Element.Extra] must be valid tokens, otherwise
+// [Element.String] writes an invalid element.
+func ValidToken(s string) bool {
+	return validElementToken(s)
+}
+
 // escape returns string s as token or quoted-string per
-// RFC 7230, section 3.2.6.
+// RFC 7230, section 3.2.6. String s is only quoted if it
+// is not a valid token, so the empty string is quoted.
 func escape(s string) string {
-	if !strings.ContainsAny(s, `"(),/:;<=>?@[\]{}`) {
+	if validElementToken(s) {
 		return s
 	}
+	return string(AppendEscape(make([]byte, 0, escapedLen(s)), s))
+}
 
-	buf := make([]byte, 0, 3*len(s)/2)
-	buf = append(buf, '"')
+// AppendEscape appends value s as token or quoted-string, like
+// [EscapeValue], to dst and returns the extended buffer.
+func AppendEscape(dst []byte, s string) []byte {
+	if validElementToken(s) {
+		return append(dst, s...)
+	}
 
+	dst = append(dst, '"')
 	for i := 0; i < len(s); i++ {
 		c := s[i]
 		switch c {
 		case '"', '\\':
-			buf = append(buf, '\\', c)
+			dst = append(dst, '\\', c)
 		default:
-			buf = append(buf, c)
+			dst = append(dst, c)
 		}
 	}
+	return append(dst, '"')
+}
 
-	buf = append(buf, '"')
-	return string(buf)
+// validValue reports whether string s can be written as token
+// or quoted-string, which cannot contain control characters
+// other than horizontal tab, not even escaped.
+func validValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; isCTL(c) && c != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// escapedLen returns the length of escape(s) without
+// escaping string s.
+func escapedLen(s string) int {
+	if validElementToken(s) {
+		return len(s)
+	}
+
+	n := len(s) + 2
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '"' || c == '\\' {
+			n++
+		}
+	}
+	return n
 }
 
 // unescape unescapes value s per RFC 7329, section 4.
@@ -58,8 +120,9 @@ func unescape(s string) (string, error) {
 		return u, nil
 	}
 
-	// string needs to be unescaped
-	buf := make([]byte, 0, 3*len(s)/2)
+	// string needs to be unescaped, the result is never
+	// longer than s because backslashes are removed
+	buf := make([]byte, 0, len(s))
 	backslash := false
 	for i := 1; i < len(s)-1; i++ {
 		c := s[i]
@@ -84,3 +147,146 @@ func unescape(s string) (string, error) {
 
 	return string(buf), nil
 }
+
+// validBareProto reports whether s is a proto value that is sent
+// without quotes although it contains a "/", like "http/1.1".
+// Such values are not valid tokens, but are sent by gateways.
+func validBareProto(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c != '/' && !isTokenTable[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// unterminated reports whether s starts a quoted-string
+// that is not closed by an unescaped DQUOTE.
+func unterminated(s string) bool {
+	if !strings.HasPrefix(s, `"`) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return false
+		}
+	}
+	return true
+}
+
+// indexUnquoted returns the index of the first instance of c
+// in s that is not part of a quoted-string, or -1 if c is not
+// present. It also reports whether s ends in a quoted-string
+// that is not closed.
+func indexUnquoted(s string, c byte) (int, bool) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case quoted && b == '\\':
+			i++
+		case b == '"':
+			quoted = !quoted
+		case !quoted && b == c:
+			return i, false
+		}
+	}
+	return -1, quoted
+}
+
+// splitUnquotedSeq returns an iterator over the substrings
+// of s separated by sep outside quoted-strings.
+func splitUnquotedSeq(s string, sep byte) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for {
+			i, _ := indexUnquoted(s, sep)
+			if i == -1 {
+				yield(s)
+				return
+			}
+			if !yield(s[:i]) {
+				return
+			}
+			s = s[i+1:]
+		}
+	}
+}
+
+// quoteState is the state of a scan for separators outside
+// quoted-strings, like done by indexUnquoted.
+type quoteState struct {
+	quoted, escaped bool
+}
+
+// step advances state q over byte b and reports whether b is
+// separator sep outside a quoted-string.
+func (q *quoteState) step(b, sep byte) bool {
+	switch {
+	case q.escaped:
+		q.escaped = false
+	case q.quoted && b == '\\':
+		q.escaped = true
+	case b == '"':
+		q.quoted = !q.quoted
+	case !q.quoted && b == sep:
+		return true
+	}
+	return false
+}
+
+// reverseBlockSize is the number of bytes of which
+// reverseSplitUnquotedSeq keeps the separators at once.
+const reverseBlockSize = 1024
+
+// reverseSplitUnquotedSeq is like splitUnquotedSeq, but yields
+// the substrings in reverse.
+func reverseSplitUnquotedSeq(s string, sep byte) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if strings.IndexByte(s, '"') == -1 {
+			for {
+				i := strings.LastIndexByte(s, sep)
+				if !yield(s[i+1:]) || i == -1 {
+					return
+				}
+				s = s[:i]
+			}
+		}
+
+		// Quoted-strings can only be recognized from the start,
+		// so keep the scan state at the start of every block and
+		// then find the separators block by block from the end.
+		// This keeps memory small even for a huge line.
+		var states []quoteState
+		var q quoteState
+		for i := 0; i < len(s); i++ {
+			if i%reverseBlockSize == 0 {
+				states = append(states, q)
+			}
+			q.step(s[i], sep)
+		}
+
+		end := len(s)
+		var seps []int
+		for k, q := range slices.Backward(states) {
+			seps = seps[:0]
+			start := k * reverseBlockSize
+			for i := start; i < min(start+reverseBlockSize, len(s)); i++ {
+				if q.step(s[i], sep) {
+					seps = append(seps, i)
+				}
+			}
+			for _, i := range slices.Backward(seps) {
+				if !yield(s[i+1 : end]) {
+					return
+				}
+				end = i
+			}
+		}
+		yield(s[:end])
+	}
+}
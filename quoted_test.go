@@ -1,7 +1,10 @@
 package forwarded
 
 import (
+	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -16,8 +19,15 @@ var escapeTests = []struct {
 	{"[2001:db8:cafe::17]:47011", `"[2001:db8:cafe::17]:47011"`},
 	{"unknown", `unknown`},
 
-	{``, ""},
+	{"x-Key_1.2~!#$%&'*+^`|", "x-Key_1.2~!#$%&'*+^`|"},
+
+	{``, `""`},
 	{`"`, `"\""`},
+	{`\`, `"\\"`},
+	{"a b", `"a b"`},
+	{"a\tb", "\"a\tb\""},
+	{"\u00e9", "\"\u00e9\""},
+	{"a,b;c=d", `"a,b;c=d"`},
 }
 
 func TestEscape(t *testing.T) {
@@ -29,6 +39,83 @@ func TestEscape(t *testing.T) {
 	}
 }
 
+func TestEscapeValue(t *testing.T) {
+	for _, c := range escapeTests {
+		got := EscapeValue(c.in)
+		if got != c.want {
+			t.Errorf("EscapeValue(%q) = %q, want: %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestAppendEscape(t *testing.T) {
+	for _, c := range escapeTests {
+		got := AppendEscape([]byte("key="), c.in)
+		if want := "key=" + c.want; string(got) != want {
+			t.Errorf("AppendEscape(%q) = %q, want: %q", c.in, got, want)
+		}
+	}
+}
+
+func TestEscapedLen(t *testing.T) {
+	for _, c := range escapeTests {
+		if got := escapedLen(c.in); got != len(c.want) {
+			t.Errorf("escapedLen(%q) = %d, want: %d", c.in, got, len(c.want))
+		}
+	}
+}
+
+func TestEscapeRoundTrip(t *testing.T) {
+	values := []string{
+		"", " ", "\t", "a", "a b", " a ", "unknown", "_gazonk",
+		`"`, `\`, `\"`, `"\`, `""`, `"a"`, `\\`,
+		"192.0.2.43:47011", "[2001:db8:cafe::17]:47011",
+		"a,b", "a;b", "a=b", "(a)", "<a>", "a/b", "a?b", "a@b",
+		"{a}", "[a]", "a:b", "!#$%&'*+-.^_`|~",
+		"\u00e9", "\xff", "\x80\"\x80",
+	}
+	for c := byte(0x20); c < 0x7f; c++ {
+		values = append(values, string(c), "a"+string(c)+"b")
+	}
+
+	for _, s := range values {
+		e := escape(s)
+		got, err := unescape(e)
+		if err != nil || got != s {
+			t.Errorf("unescape(escape(%q)) = (%q, %v), want: (%q, nil)", s, got, err, s)
+		}
+		if quoted := strings.HasPrefix(e, `"`); quoted == validElementToken(s) {
+			t.Errorf("escape(%q) = %s, quoted: %v, want quoted: %v", s, e, quoted, !validElementToken(s))
+		}
+		if n := escapedLen(s); n != len(e) {
+			t.Errorf("escapedLen(%q) = %d, want: %d", s, n, len(e))
+		}
+	}
+}
+
+func FuzzEscape(f *testing.F) {
+	for _, c := range escapeTests {
+		f.Add(c.in)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		e := escape(s)
+		got, err := unescape(e)
+		if !validValue(s) {
+			if err == nil {
+				t.Fatalf("unescape(escape(%q)) = %q, want error", s, got)
+			}
+			return
+		}
+		if err != nil || got != s {
+			t.Fatalf("unescape(escape(%q)) = (%q, %v), want: (%q, nil)", s, got, err, s)
+		}
+		if n := escapedLen(s); n != len(e) {
+			t.Fatalf("escapedLen(%q) = %d, want: %d", s, n, len(e))
+		}
+	})
+}
+
 func BenchmarkEscape(b *testing.B) {
 	tokens := []string{
 		"_gazonk",
@@ -85,6 +172,59 @@ func TestUnescape(t *testing.T) {
 	}
 }
 
+func TestUnterminated(t *testing.T) {
+	cases := []struct {
+		in   string
+		want bool
+	}{
+		{`unknown`, false},
+		{`""`, false},
+		{`"_gazonk"`, false},
+		{`"\""`, false},
+		{`"`, true},
+		{`"_gazonk`, true},
+		{`"_gazonk\"`, true},
+	}
+
+	for _, c := range cases {
+		if got := unterminated(c.in); got != c.want {
+			t.Errorf("unterminated(%s) = %v, want: %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestUnescapeValue(t *testing.T) {
+	for _, c := range unescapeTests {
+		got, err := UnescapeValue(c.in)
+
+		if got != c.want || err == nil && c.err != "" || err != nil && err.Error() != c.err {
+			t.Errorf("UnescapeValue(%s) = (%q, %v), want: (%q, %v)", c.in, got, err, c.want, c.err)
+		}
+	}
+}
+
+func TestIndexUnquoted(t *testing.T) {
+	cases := []struct {
+		in   string
+		i    int
+		open bool
+	}{
+		{`for=a,for=b`, 5, false},
+		{`host="a,b",for=b`, 10, false},
+		{`key="\",",for=b`, 9, false},
+		{`key="\\",for=b`, 8, false},
+		{`for=a`, -1, false},
+		{`host="a,b`, -1, true},
+	}
+
+	for _, c := range cases {
+		i, open := indexUnquoted(c.in, ',')
+		if i != c.i || open != c.open {
+			t.Errorf("indexUnquoted(%s, ',') = (%d, %v), want: (%d, %v)", c.in, i, open, c.i, c.open)
+		}
+	}
+}
+
 func BenchmarkUnescape(b *testing.B) {
 	tokens := []string{
 		`"_gazonk"`,
@@ -101,6 +241,24 @@ func BenchmarkUnescape(b *testing.B) {
 	}
 }
 
+func BenchmarkUnescapeEscaped(b *testing.B) {
+	tokens := map[string]string{
+		"few":  `"[2001:db8:cafe::17]:4711 \"gazonk\""`,
+		"many": `"\"\\\"\\\"\\\"\\\"\\\"\\\"\""`,
+	}
+	for name, token := range tokens {
+		b.Run(name, func(b *testing.B) {
+			if _, err := unescape(token); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			for b.Loop() {
+				unescape(token)
+			}
+		})
+	}
+}
+
 func BenchmarkStrconvUnquote(b *testing.B) {
 	tokens := []string{
 		`"_gazonk"`,
@@ -117,6 +275,42 @@ func BenchmarkStrconvUnquote(b *testing.B) {
 	}
 }
 
+func TestTrimOWS(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"", ""},
+		{" \t", ""},
+		{" a b\t", "a b"},
+		{"\u00a0a", "\u00a0a"},
+		{"\na\r", "\na\r"},
+	}
+	for _, c := range cases {
+		if got := TrimOWS(c.in); got != c.want {
+			t.Errorf("TrimOWS(%q) = %q, want: %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestValidToken(t *testing.T) {
+	cases := []struct {
+		in   string
+		want bool
+	}{
+		{"", false},
+		{"for", true},
+		{"x-Key_1.2~!#$%&'*+^`|", true},
+		{"a b", false},
+		{"a=b", false},
+		{`"a"`, false},
+		{"a;b", false},
+		{"\u00e9", false},
+	}
+	for _, c := range cases {
+		if got := ValidToken(c.in); got != c.want {
+			t.Errorf("ValidToken(%q) = %v, want: %v", c.in, got, c.want)
+		}
+	}
+}
+
 func BenchmarkValidElementToken(b *testing.B) {
 	names := []string{
 		"",
@@ -134,3 +328,90 @@ func BenchmarkValidElementToken(b *testing.B) {
 		}
 	}
 }
+
+func TestSplitUnquotedSeq(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{""}},
+		{"a", []string{"a"}},
+		{"a,b,", []string{"a", "b", ""}},
+		{`a="1,2",b`, []string{`a="1,2"`, "b"}},
+		{`a="\",",b="`, []string{`a="\","`, `b="`}},
+		{`a="1,2`, []string{`a="1,2`}},
+		{`a=";";b`, []string{`a=";";b`}},
+	}
+
+	for _, c := range cases {
+		got := slices.Collect(splitUnquotedSeq(c.in, ','))
+		if !slices.Equal(got, c.want) {
+			t.Errorf("splitUnquotedSeq(%q) = %q, want: %q", c.in, got, c.want)
+		}
+
+		got = slices.Collect(reverseSplitUnquotedSeq(c.in, ','))
+		slices.Reverse(got)
+		if !slices.Equal(got, c.want) {
+			t.Errorf("reverseSplitUnquotedSeq(%q) = %q, want reversed: %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestSplitUnquotedSeqSemicolon(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{`for=1.2.3.4;host="a;b"`, []string{"for=1.2.3.4", `host="a;b"`}},
+		{`a="\";";b`, []string{`a="\";"`, "b"}},
+		{`a="\\";b="c"`, []string{`a="\\"`, `b="c"`}},
+		{`a="x\"`, []string{`a="x\"`}},
+	}
+
+	for _, c := range cases {
+		got := slices.Collect(splitUnquotedSeq(c.in, ';'))
+		if !slices.Equal(got, c.want) {
+			t.Errorf("splitUnquotedSeq(%q, ';') = %q, want: %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestReverseSplitUnquotedSeqLarge(t *testing.T) {
+	// quoted-strings with escapes and separators crossing block boundaries
+	var sb strings.Builder
+	for i := 0; sb.Len() < 5*reverseBlockSize; i++ {
+		fmt.Fprintf(&sb, `for=a%d;host="x,\",%s",`, i, strings.Repeat(",", i%7))
+	}
+	line := sb.String()
+
+	want := slices.Collect(splitUnquotedSeq(line, ','))
+	got := slices.Collect(reverseSplitUnquotedSeq(line, ','))
+	slices.Reverse(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("reverseSplitUnquotedSeq(large) = %d substrings, want: %d equal substrings", len(got), len(want))
+	}
+}
+
+func TestReverseSplitUnquotedSeqAllocs(t *testing.T) {
+	commas := strings.Repeat(",", 1<<20)
+	quoted := `a="b",` + commas
+	for _, line := range []string{commas, quoted} {
+		allocs := testing.AllocsPerRun(10, func() {
+			for range reverseSplitUnquotedSeq(line, ',') {
+			}
+		})
+		// one state per block and one reused separator buffer
+		if allocs > 32 {
+			t.Errorf("reverseSplitUnquotedSeq(%d bytes) = %v allocs, want at most 32", len(line), allocs)
+		}
+	}
+}
+
+func BenchmarkReverseSplitUnquotedSeq(b *testing.B) {
+	line := `a="b",` + strings.Repeat(",", 1<<20)
+	b.ReportAllocs()
+	for b.Loop() {
+		for range reverseSplitUnquotedSeq(line, ',') {
+		}
+	}
+}
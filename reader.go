@@ -0,0 +1,88 @@
+package forwarded
+
+import (
+	"fmt"
+	"io"
+	"iter"
+)
+
+// readSize is the number of bytes ParseReader reads at once.
+const readSize = 4096
+
+// ParseReader is like [Parse], but reads the line from r. If
+// reverse is false, elements are parsed and yielded as soon as
+// they are read, so the line is never fully buffered. If reverse
+// is true, the line is read fully before parsing. Commas inside
+// quoted-strings do not separate elements.
+// An error reading from r is yielded wrapped and is not of type
+// [*ParseError]. Other errors returned are of type [*ParseError].
+func ParseReader(r io.Reader, reverse bool) iter.Seq2[*Element, error] {
+	return func(yield func(*Element, error) bool) {
+		if reverse {
+			line, err := io.ReadAll(r)
+			if err != nil {
+				yield(nil, fmt.Errorf("forwarded: read line: %w", err))
+				return
+			}
+			for elem, err := range Parse(string(line), true) {
+				if !yield(elem, err) {
+					return
+				}
+			}
+			return
+		}
+
+		// pending holds the text after the last element yielded,
+		// which starts at offset off in the line. The first scan
+		// bytes of pending are scanned for a comma already, quoted
+		// and escaped hold the state of the scan after them, so
+		// every byte read is only scanned once.
+		var pending []byte
+		off := 0
+		scan := 0
+		quoted, escaped := false, false
+		next := func(elem string) bool {
+			if trimOWS(elem) == "" {
+				return true
+			}
+			var e Element
+			if err := parseElement(&e, elem, nil); err != nil {
+				yield(nil, errorAt(err, off))
+				return false
+			}
+			return yield(&e, nil)
+		}
+
+		buf := make([]byte, readSize)
+		for {
+			n, rerr := r.Read(buf)
+			pending = append(pending, buf[:n]...)
+			for ; scan < len(pending); scan++ {
+				switch b := pending[scan]; {
+				case escaped:
+					escaped = false
+				case quoted && b == '\\':
+					escaped = true
+				case b == '"':
+					quoted = !quoted
+				case !quoted && b == ',':
+					if !next(string(pending[:scan])) {
+						return
+					}
+					off += scan + 1
+					pending = pending[scan+1:]
+					scan = -1
+				}
+			}
+
+			switch {
+			case rerr == io.EOF:
+				next(string(pending))
+				return
+			case rerr != nil:
+				yield(nil, fmt.Errorf("forwarded: read line: %w", rerr))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package forwarded
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseReader(t *testing.T) {
+	for _, c := range parseTests {
+		for _, reverse := range []bool{false, true} {
+			var got []*Element
+			r := iotest.OneByteReader(strings.NewReader(c.in))
+			for elem, err := range ParseReader(r, reverse) {
+				if err != nil {
+					t.Fatalf("%s: got error: %v", c.name, err)
+				}
+				got = append(got, elem)
+			}
+			if reverse {
+				slices.Reverse(got)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s (reverse=%v):\ngot:  %v\nwant: %v", c.name, reverse, got, c.want)
+			}
+		}
+	}
+
+	for _, c := range parseErrorTests {
+		var err error
+		for _, err = range ParseReader(strings.NewReader(c.in), false) {
+			if err != nil {
+				break
+			}
+		}
+		if pe, ok := err.(*ParseError); !ok || pe.Msg != c.msg {
+			t.Errorf("ParseReader(%s) error = %v, want %q", c.in, err, c.msg)
+		}
+	}
+}
+
+func TestParseReaderStream(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte(`for=192.0.2.43;host="a`))
+		pw.Write([]byte(`,b", for=198.51.100.17`))
+		pw.Write([]byte(`, for`))
+		pw.Close()
+	}()
+
+	var got []*Element
+	var err error
+	for elem, perr := range ParseReader(pr, false) {
+		if perr != nil {
+			err = perr
+			break
+		}
+		got = append(got, elem)
+	}
+	want := []*Element{{For: "192.0.2.43", Host: "a,b"}, {For: "198.51.100.17"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseReader() = %v, want: %v", got, want)
+	}
+	if pe, ok := err.(*ParseError); !ok || pe.Offset != 46 {
+		t.Errorf("ParseReader() error = %v, want parse error at offset 46", err)
+	}
+}
+
+// largeLine returns a line with an element of which the host is
+// a quoted-string of about n bytes, containing escaped quotes and
+// commas, followed by a small element.
+func largeLine(n int) (string, string) {
+	host := strings.Repeat(`a,b\"c;`, n/8)
+	return `for=192.0.2.43;host="` + host + `", for=198.51.100.17`, strings.ReplaceAll(host, `\"`, `"`)
+}
+
+func TestParseReaderLarge(t *testing.T) {
+	line, host := largeLine(4*readSize + 3)
+	want := []*Element{{For: "192.0.2.43", Host: host}, {For: "198.51.100.17"}}
+
+	var got []*Element
+	for elem, err := range ParseReader(iotest.HalfReader(strings.NewReader(line)), false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, elem)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseReader(large) = %d elements, want: %d equal elements", len(got), len(want))
+	}
+}
+
+func TestParseReaderReadError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	for _, reverse := range []bool{false, true} {
+		r := io.MultiReader(strings.NewReader("for=192.0.2.43, for=198.51"), iotest.ErrReader(readErr))
+		var err error
+		n := 0
+		for _, err = range ParseReader(r, reverse) {
+			if err != nil {
+				break
+			}
+			n++
+		}
+		if !errors.Is(err, readErr) {
+			t.Errorf("ParseReader(reverse=%v) error = %v, want %v", reverse, err, readErr)
+		}
+		if _, ok := err.(*ParseError); ok {
+			t.Errorf("ParseReader(reverse=%v) read error is a *ParseError", reverse)
+		}
+		if want := map[bool]int{false: 1, true: 0}[reverse]; n != want {
+			t.Errorf("ParseReader(reverse=%v) yielded %d elements, want: %d", reverse, n, want)
+		}
+	}
+}
+
+func BenchmarkParseReaderLarge(b *testing.B) {
+	line, _ := largeLine(2 << 20)
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, err := range ParseReader(strings.NewReader(line), false) {
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package forwarded
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Report returns a human-readable report of chain c for
+// troubleshooting. For every element it lists the element, whether
+// the for node is an IP address and whether that address is in one
+// of the trusted prefixes, and any problems [ParseStrict] would
+// report. Problems of the chain as a whole are listed last.
+func (c Chain) Report(trusted []netip.Prefix) string {
+	var b strings.Builder
+	if len(c) == 0 {
+		b.WriteString("no elements\n")
+	}
+
+	for i, e := range c {
+		fmt.Fprintf(&b, "hop %d: %s\n", i+1, e)
+
+		addr, _, _ := e.For.AddrPort()
+		switch {
+		case e.For == "":
+			b.WriteString("\tfor: missing\n")
+		case !addr.IsValid():
+			fmt.Fprintf(&b, "\tfor: %q is not an address, untrusted\n", e.For)
+		case isTrusted(e.For, trusted):
+			fmt.Fprintf(&b, "\tfor: %s, trusted\n", addr)
+		default:
+			fmt.Fprintf(&b, "\tfor: %s, untrusted\n", addr)
+		}
+
+		for _, w := range e.warnings() {
+			fmt.Fprintf(&b, "\twarning: %s\n", w)
+		}
+	}
+
+	if c.ProtoConflict() {
+		b.WriteString("warning: proto changes to https after a hop without https\n")
+	}
+	return b.String()
+}
+
+// warnings returns the problems found in element e.
+func (e *Element) warnings() []string {
+	var ws []string
+	check := func(err error) {
+		if pe, ok := err.(*ParseError); ok {
+			ws = append(ws, fmt.Sprintf("%s %q", pe.Msg, pe.Text))
+		}
+	}
+
+	for _, n := range []Node{e.For, e.By} {
+		if n == "" {
+			continue
+		}
+		check(strictOptions.checkNode(n))
+		if n.IsAmbiguous() {
+			ws = append(ws, fmt.Sprintf("ambiguous node %q", n))
+		}
+	}
+	if e.Proto != "" {
+		check(strictOptions.checkProto(e.Proto))
+	}
+	if e.Host != "" {
+		check(strictOptions.checkHost(e.Host))
+	}
+	return ws
+}
@@ -0,0 +1,41 @@
+package forwarded
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChainReport(t *testing.T) {
+	chain := Chain{
+		{For: "192.0.2.43", Proto: "http"},
+		{For: "_192.0.2.1", By: "_ga zonk", Proto: "https"},
+		{For: "10.0.0.1", Proto: "1http", Host: "exa mple.com"},
+		{By: "10.0.0.2"},
+	}
+	got := chain.Report(trustedPrefixes)
+
+	want := []string{
+		"hop 1: for=192.0.2.43;proto=http\n",
+		"\tfor: 192.0.2.43, untrusted\n",
+		"hop 2: ",
+		"\tfor: \"_192.0.2.1\" is not an address, untrusted\n",
+		"\twarning: ambiguous node \"_192.0.2.1\"\n",
+		"\twarning: invalid obfuscated node \"_ga zonk\"\n",
+		"hop 3: ",
+		"\tfor: 10.0.0.1, trusted\n",
+		"\twarning: invalid proto \"1http\"\n",
+		"\twarning: invalid host \"exa mple.com\"\n",
+		"hop 4: by=10.0.0.2\n",
+		"\tfor: missing\n",
+		"warning: proto changes to https after a hop without https\n",
+	}
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("Report() does not contain %q:\n%s", w, got)
+		}
+	}
+
+	if got := Chain(nil).Report(trustedPrefixes); got != "no elements\n" {
+		t.Errorf("Chain(nil).Report() = %q, want: %q", got, "no elements\n")
+	}
+}
@@ -0,0 +1,175 @@
+package forwarded
+
+import (
+	"iter"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ParseStrict is like [Parse], but rejects anything that does
+// not conform to RFC 7239. In addition to the checks done by
+// [Parse] it rejects:
+//   - empty elements, such as caused by stray commas, which
+//     [Parse] skips;
+//   - whitespace around "=" in a parameter, which [Parse] trims;
+//   - a by, for, proto or host parameter that occurs more
+//     than once in an element, which [Parse] overwrites with
+//     the last value;
+//   - obfuscated node names and ports with characters other
+//     than ALPHA, DIGIT, ".", "_" and "-";
+//   - by and for nodes that are not an IPv4 address, a bracketed
+//     IPv6 address, "unknown" or an obfuscated identifier, with
+//     an optional numeric or obfuscated port;
+//   - numeric node ports that are longer than five digits
+//     or larger than 65535;
+//   - numeric node ports with leading zeros, as "0443" and "443"
+//     are the same port and naive string comparisons could be
+//     evaded;
+//   - a proto value that is not a URI scheme name;
+//   - a host value that is not a valid Host header value;
+//   - values containing obs-text (bytes 0x80 to 0xff).
+//
+// As a scheme name is case-insensitive, proto values are
+// lowercased, so "HTTPS" is parsed as "https".
+// The error returned is of type [*ParseError].
+func ParseStrict(line string, reverse bool) iter.Seq2[*Element, error] {
+	return parse(line, reverse, &strictOptions)
+}
+
+// isDigits reports whether s is a non-empty string of digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validPort reports whether s is a port per RFC 7239,
+// section 6 that fits in 16 bits:
+//
+//	port = 1*5DIGIT
+func validPort(s string) bool {
+	if len(s) > 5 || !isDigits(s) {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 10, 16)
+	return err == nil
+}
+
+// validNode reports whether n is a node per RFC 7239, section 6:
+//
+//	node      = nodename [ ":" node-port ]
+//	nodename  = IPv4address / "[" IPv6address "]" /
+//	            "unknown" / obfnode
+//	node-port = port / obfport
+func validNode(n Node) bool {
+	s := string(n)
+	name, port, hasPort := s, "", false
+	if strings.HasPrefix(s, "[") {
+		i := strings.IndexByte(s, ']')
+		if i == -1 {
+			return false
+		}
+		a, err := netip.ParseAddr(s[1:i])
+		if err != nil || !a.Is6() || a.Zone() != "" {
+			return false
+		}
+		if rest := s[i+1:]; rest != "" {
+			if rest[0] != ':' {
+				return false
+			}
+			port, hasPort = rest[1:], true
+		}
+	} else {
+		name, port, hasPort = strings.Cut(s, ":")
+		a, err := netip.ParseAddr(name)
+		if (err != nil || !a.Is4()) && !strings.EqualFold(name, "unknown") && !validObfuscated(name) {
+			return false
+		}
+	}
+	return !hasPort || validPort(port) || validObfuscated(port)
+}
+
+// validScheme reports whether s is a URI scheme per
+// RFC 3986, section 3.1:
+//
+//	scheme = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+func validScheme(s string) bool {
+	if s == "" || !isAlpha(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if !isAlpha(c) && !isDigit(c) && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// validHost reports whether s is a Host header value per
+// RFC 7230, section 5.4:
+//
+//	Host = uri-host [ ":" port ]
+//
+// An empty host or port is rejected.
+func validHost(s string) bool {
+	host, port := s, ""
+	if strings.HasPrefix(s, "[") {
+		i := strings.IndexByte(s, ']')
+		if i == -1 {
+			return false
+		}
+		host, port = s[1:i], s[i+1:]
+		if port != "" {
+			if port[0] != ':' {
+				return false
+			}
+			port = port[1:]
+			if !validPort(port) {
+				return false
+			}
+		}
+		a, err := netip.ParseAddr(host)
+		return err == nil && a.Is6() && a.Zone() == ""
+	}
+
+	if i := strings.LastIndexByte(s, ':'); i != -1 {
+		host, port = s[:i], s[i+1:]
+		if !validPort(port) {
+			return false
+		}
+	}
+	if host == "" {
+		return false
+	}
+	for i := 0; i < len(host); i++ {
+		if !isRegNameChar(host[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isRegNameChar reports whether c may occur in a reg-name
+// per RFC 3986, section 3.2.2:
+//
+//	reg-name   = *( unreserved / pct-encoded / sub-delims )
+//	unreserved = ALPHA / DIGIT / "-" / "." / "_" / "~"
+//	sub-delims = "!" / "$" / "&" / "'" / "(" / ")"
+//	           / "*" / "+" / "," / ";" / "="
+func isRegNameChar(c byte) bool {
+	if isAlpha(c) || isDigit(c) {
+		return true
+	}
+	return strings.IndexByte("-._~%!$&'()*+,;=", c) != -1
+}
+
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
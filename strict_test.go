@@ -0,0 +1,157 @@
+package forwarded
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseStrict(t *testing.T) {
+	for _, c := range parseTests {
+		if c.name == "quoted/semicolon/for" {
+			continue // "a;b" is not a valid node
+		}
+		t.Run(c.name, func(t *testing.T) {
+			for _, err := range ParseStrict(c.in, false) {
+				if err != nil {
+					t.Errorf("ParseStrict(%s) error: %v", c.in, err)
+				}
+			}
+		})
+	}
+
+	cases := []struct {
+		in  string
+		msg string
+	}{
+		{`for=192.0.2.43;for=198.51.100.17`, "duplicate parameter"},
+		{`for=192.0.2.43;By=_a;by=_b`, "duplicate parameter"},
+		{`proto=http;proto=https`, "duplicate parameter"},
+		{`host=a.example;host=b.example`, "duplicate parameter"},
+		{`for="_ga zonk"`, "invalid obfuscated node"},
+		{`for="192.0.2.43:_ga zonk"`, "invalid obfuscated port"},
+		{`for="192.0.2.43:65536"`, "invalid port"},
+		{`for="192.0.2.43:000080"`, "invalid port"},
+		{`for="192.0.2.43:0443"`, "leading zero in port"},
+		{`for="a;b"`, "invalid node"},
+		{`for=example.com`, "invalid node"},
+		{`by="2001:db8:cafe::17"`, "invalid node"},
+		{`for="[192.0.2.43]"`, "invalid node"},
+		{`for="[fe80::1%eth0]"`, "invalid node"},
+		{`for="[2001:db8:cafe::17]:00"`, "leading zero in port"},
+		{`proto=1http`, "invalid proto"},
+		{`proto="ht tp"`, "invalid proto"},
+		{`proto=http/1.1`, "invalid proto"},
+		{`host="exa mple.com"`, "invalid host"},
+		{`host="example.com:http"`, "invalid host"},
+		{`host="[2001:db8::17"`, "invalid host"},
+		{`host="[192.0.2.43]"`, "invalid host"},
+		{"for=\"\xc3\xa9\"", "obs-text found in"},
+	}
+
+	for _, c := range cases {
+		for _, err := range Parse(c.in, false) {
+			if err != nil {
+				t.Errorf("Parse(%s) error: %v", c.in, err)
+			}
+		}
+
+		var err error
+		for _, err = range ParseStrict(c.in, false) {
+			if err != nil {
+				break
+			}
+		}
+		if pe, ok := err.(*ParseError); !ok || pe.Msg != c.msg {
+			t.Errorf("ParseStrict(%s) error = %v, want %q", c.in, err, c.msg)
+		}
+	}
+}
+
+func TestParseStrictDuplicate(t *testing.T) {
+	values := map[string][2]string{
+		"by":    {"_a", "_b"},
+		"for":   {"192.0.2.43", "unknown"},
+		"proto": {"http", "https"},
+		"host":  {"a.example", "b.example"},
+	}
+	for key, v := range values {
+		in := key + "=" + v[0] + ";" + key + "=" + v[1]
+		for _, reverse := range []bool{false, true} {
+			var err error
+			for _, err = range ParseStrict(in, reverse) {
+				if err != nil {
+					break
+				}
+			}
+			pe, ok := err.(*ParseError)
+			if !ok || !errors.Is(err, ErrDuplicateParameter) || pe.Text != key || pe.Offset != len(key)+len(v[0])+2 {
+				t.Errorf("ParseStrict(%s) error = %v, want duplicate parameter %q", in, err, key)
+			}
+		}
+
+		elems, err := ParseAll(in, false)
+		if err != nil {
+			t.Fatalf("ParseAll(%s) error: %v", in, err)
+		}
+		if got := elems[0].Params()[key]; got != v[1] {
+			t.Errorf("ParseAll(%s) %s = %q, want last value %q", in, key, got, v[1])
+		}
+	}
+}
+
+func TestParseStrictPortZero(t *testing.T) {
+	for _, err := range ParseStrict(`for="192.0.2.43:0"`, false) {
+		if err != nil {
+			t.Errorf("ParseStrict(port 0) error: %v", err)
+		}
+	}
+	if port, ok := NodePort("0443").Uint16(); port != 443 || !ok {
+		t.Errorf(`NodePort("0443").Uint16() = (%d, %v), want: (443, true)`, port, ok)
+	}
+}
+
+func TestValidHost(t *testing.T) {
+	cases := []struct {
+		in   string
+		want bool
+	}{
+		{"example.com", true},
+		{"example.com:8080", true},
+		{"192.0.2.43", true},
+		{"192.0.2.43:80", true},
+		{"[2001:db8:cafe::17]", true},
+		{"[2001:db8:cafe::17]:443", true},
+		{"", false},
+		{":80", false},
+		{"example.com:", false},
+		{"[2001:db8:cafe::17]443", false},
+		{"exa/mple.com", false},
+	}
+
+	for _, c := range cases {
+		if got := validHost(c.in); got != c.want {
+			t.Errorf("validHost(%q) = %v, want: %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseStrictLowerProto(t *testing.T) {
+	line := `proto=HTTPS, proto="Http", proto=h2c`
+	want := []string{"https", "http", "h2c"}
+
+	var got []string
+	for elem, err := range ParseStrict(line, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, elem.Proto)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseStrict(%s) protos = %q, want: %q", line, got, want)
+	}
+
+	if elem, _ := First(line); elem.Proto != "HTTPS" {
+		t.Errorf("First(%s) proto = %q, want: %q", line, elem.Proto, "HTTPS")
+	}
+}
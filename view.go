@@ -0,0 +1,151 @@
+package forwarded
+
+import (
+	"iter"
+	"strings"
+)
+
+// ElementView is a view of an element in a line. It refers to
+// the line it is parsed from instead of copying values, so the
+// line is retained for as long as the view or any string returned
+// by it is in use.
+type ElementView struct {
+	line  string
+	elem  span
+	by    span
+	for_  span
+	proto span
+	host  span
+}
+
+// span is a range of bytes in a line.
+type span struct {
+	start, end int
+}
+
+// ParseView parses the elements in the given line into views.
+// Empty elements are skipped and optional whitespace around "="
+// is trimmed like [Parse] does.
+// Only the structure of the line is checked: parameters must
+// have a valid name and quoted-strings must be terminated.
+// Values are not unescaped or validated.
+// The error returned is of type [*ParseError].
+func ParseView(line string) iter.Seq2[ElementView, error] {
+	return func(yield func(ElementView, error) bool) {
+		for start := 0; ; {
+			end := len(line)
+			i, _ := indexUnquoted(line[start:], ',')
+			if i != -1 {
+				end = start + i
+			}
+
+			if s := trimSpan(line, span{start, end}); s.start == s.end {
+				if i == -1 {
+					return
+				}
+				start = end + 1
+				continue
+			}
+			v, err := parseView(line, span{start, end})
+			if err != nil {
+				yield(ElementView{}, err)
+				return
+			}
+			if !yield(v, nil) || i == -1 {
+				return
+			}
+			start = end + 1
+		}
+	}
+}
+
+func parseView(line string, elem span) (ElementView, error) {
+	v := ElementView{line: line, elem: elem}
+	for start := elem.start; ; {
+		end := elem.end
+		i, _ := indexUnquoted(line[start:end], ';')
+		if i != -1 {
+			end = start + i
+		}
+
+		pair := trimSpan(line, span{start, end})
+		text := line[pair.start:pair.end]
+		eq := strings.IndexByte(text, '=')
+		if eq == -1 {
+			return ElementView{}, &ParseError{Msg: `no "=" found in`, Text: text, Offset: pair.start, err: ErrNoEquals}
+		}
+		ts := trimSpan(line, span{pair.start, pair.start + eq})
+		vs := trimSpan(line, span{pair.start + eq + 1, pair.end})
+		token, value := line[ts.start:ts.end], line[vs.start:vs.end]
+		if !validElementToken(token) {
+			return ElementView{}, &ParseError{Msg: `invalid token`, Text: token, Offset: ts.start, err: ErrInvalidToken}
+		}
+		if unterminated(value) {
+			return ElementView{}, &ParseError{Msg: `unterminated quoted-string`, Text: value, Offset: vs.start, err: ErrUnterminatedQuote}
+		}
+
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			vs.start++
+			vs.end--
+		}
+		switch {
+		case strings.EqualFold(token, "by"):
+			v.by = vs
+		case strings.EqualFold(token, "for"):
+			v.for_ = vs
+		case strings.EqualFold(token, "proto"):
+			v.proto = vs
+		case strings.EqualFold(token, "host"):
+			v.host = vs
+		}
+
+		if i == -1 {
+			return v, nil
+		}
+		start = end + 1
+	}
+}
+
+// trimSpan returns span s in line without surrounding
+// optional whitespace.
+func trimSpan(line string, s span) span {
+	for s.start < s.end && isOWS(line[s.start]) {
+		s.start++
+	}
+	for s.end > s.start && isOWS(line[s.end-1]) {
+		s.end--
+	}
+	return s
+}
+
+// By returns the raw value of the by parameter. Surrounding
+// quotes are removed, but escapes are kept.
+func (v ElementView) By() string { return v.line[v.by.start:v.by.end] }
+
+// For returns the raw value of the for parameter. Surrounding
+// quotes are removed, but escapes are kept.
+func (v ElementView) For() string { return v.line[v.for_.start:v.for_.end] }
+
+// Proto returns the raw value of the proto parameter.
+// Surrounding quotes are removed, but escapes are kept.
+func (v ElementView) Proto() string { return v.line[v.proto.start:v.proto.end] }
+
+// Host returns the raw value of the host parameter.
+// Surrounding quotes are removed, but escapes are kept.
+func (v ElementView) Host() string { return v.line[v.host.start:v.host.end] }
+
+// String returns the text of the element in the line.
+func (v ElementView) String() string {
+	return trimOWS(v.line[v.elem.start:v.elem.end])
+}
+
+// Element parses the view into an element, unescaping and
+// validating all values like [Parse].
+// The error returned is of type [*ParseError].
+func (v ElementView) Element() (*Element, error) {
+	var e Element
+	if err := parseElement(&e, v.line[v.elem.start:v.elem.end], nil); err != nil {
+		return nil, errorAt(err, v.elem.start)
+	}
+	return &e, nil
+}
@@ -0,0 +1,102 @@
+package forwarded
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseView(t *testing.T) {
+	line := `for=192.0.2.43;Proto=https, For="[2001:db8:cafe::17]:4711";by=_gazonk;host="a,b;c";key=value, key="\"x\""`
+
+	type fields struct{ by, for_, proto, host string }
+	want := []fields{
+		{"", "192.0.2.43", "https", ""},
+		{"_gazonk", "[2001:db8:cafe::17]:4711", "", "a,b;c"},
+		{"", "", "", ""},
+	}
+
+	wantElems := []*Element{
+		{For: "192.0.2.43", Proto: "https"},
+		{For: "[2001:db8:cafe::17]:4711", By: "_gazonk", Host: "a,b;c", Extra: []Parameter{{"key", "value"}}},
+		{Extra: []Parameter{{"key", `"x"`}}},
+	}
+
+	var got []fields
+	var gotElems []*Element
+	for v, err := range ParseView(line) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fields{v.By(), v.For(), v.Proto(), v.Host()})
+
+		e, err := v.Element()
+		if err != nil {
+			t.Fatalf("Element() of %q error: %v", v, err)
+		}
+		gotElems = append(gotElems, e)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot:  %q\nwant: %q", got, want)
+	}
+	if !reflect.DeepEqual(gotElems, wantElems) {
+		t.Errorf("\ngot:  %v\nwant: %v", gotElems, wantElems)
+	}
+
+	for _, c := range parseErrorTests {
+		var err error
+		for _, err = range ParseView(c.in) {
+			if err != nil {
+				break
+			}
+		}
+		if pe, ok := err.(*ParseError); !ok || pe.Msg != c.msg {
+			t.Errorf("ParseView(%s) error = %v, want %q", c.in, err, c.msg)
+		}
+	}
+}
+
+func TestParseViewErrorOffset(t *testing.T) {
+	line := `for=192.0.2.43, for=unknown; host="a`
+	var err error
+	for _, err = range ParseView(line) {
+		if err != nil {
+			break
+		}
+	}
+	if pe, ok := err.(*ParseError); !ok || pe.Offset != 34 || !strings.HasPrefix(line[pe.Offset:], pe.Text) {
+		t.Errorf("ParseView(%s) error = %v, want offset 34", line, err)
+	}
+}
+
+func TestElementViewElementErrorOffset(t *testing.T) {
+	line := `for=a, for=b;proto=a b`
+	var err error
+	for v, verr := range ParseView(line) {
+		if verr != nil {
+			t.Fatal(verr)
+		}
+		if _, err = v.Element(); err != nil {
+			break
+		}
+	}
+	if pe, ok := err.(*ParseError); !ok || pe.Offset != 19 || !strings.HasPrefix(line[pe.Offset:], pe.Text) {
+		t.Errorf("Element() of %s error = %v, want offset 19", line, err)
+	}
+}
+
+func BenchmarkParseView(b *testing.B) {
+	line := `for=192.0.2.43, for="[2001:db8:cafe::17]:4711";by=203.0.113.60;proto=https;host=example.com`
+	b.ReportAllocs()
+	for b.Loop() {
+		for v, err := range ParseView(line) {
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = v.For()
+			_ = v.By()
+			_ = v.Proto()
+			_ = v.Host()
+		}
+	}
+}
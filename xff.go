@@ -0,0 +1,127 @@
+package forwarded
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+const (
+	xForwardedProto = "X-Forwarded-Proto"
+	xForwardedHost  = "X-Forwarded-Host"
+)
+
+// FromXForwardedFor converts the X-Forwarded-For value xff into
+// elements with only the for node set, in the same order. IPv6
+// addresses are enclosed in square brackets as required for a node.
+// Entries that are not an IP address are used as is and empty
+// entries are skipped.
+func FromXForwardedFor(xff string) []*Element {
+	var elems []*Element
+	for entry := range strings.SplitSeq(xff, ",") {
+		entry = trimOWS(entry)
+		if entry == "" {
+			continue
+		}
+		elems = append(elems, &Element{For: xffNode(entry)})
+	}
+	return elems
+}
+
+// xffNode returns the node for X-Forwarded-For entry s.
+func xffNode(s string) Node {
+	if a, err := netip.ParseAddr(s); err == nil {
+		return NodeFromAddr(a)
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil && ap.Port() != 0 {
+		return NodeFromAddrPort(ap)
+	}
+	return Node(s)
+}
+
+// FromXForwardedHeaders converts the X-Forwarded-For,
+// X-Forwarded-Proto and X-Forwarded-Host header fields in
+// request r into elements, like [FromXForwardedFor]. The n-th
+// proto and host value is set on the n-th element, so a single
+// value describes the request received from the client by the
+// first proxy. If there is no X-Forwarded-For header, but there
+// are proto or host values, an element without for node is
+// returned for them.
+func FromXForwardedHeaders(r *http.Request) []*Element {
+	elems := FromXForwardedFor(headerLine(r.Header, xForwardedFor))
+	protos := xffValues(headerLine(r.Header, xForwardedProto))
+	hosts := xffValues(headerLine(r.Header, xForwardedHost))
+	for len(elems) < max(len(protos), len(hosts)) {
+		elems = append(elems, new(Element))
+	}
+	for i, p := range protos {
+		elems[i].Proto = p
+	}
+	for i, h := range hosts {
+		elems[i].Host = h
+	}
+	return elems
+}
+
+// xffValues returns the trimmed comma-separated values in line.
+func xffValues(line string) []string {
+	if trimOWS(line) == "" {
+		return nil
+	}
+	var values []string
+	for v := range strings.SplitSeq(line, ",") {
+		values = append(values, trimOWS(v))
+	}
+	return values
+}
+
+// ToXForwardedFor converts the for nodes of the elements into an
+// X-Forwarded-For value. The addresses are joined with ", "
+// without port and square brackets. X-Forwarded-For has no
+// equivalent of obfuscated and unknown nodes, so these and empty
+// for nodes are skipped. Use [ToXForwardedForVerbatim] to keep them.
+func ToXForwardedFor(elems []*Element) string {
+	return toXForwardedFor(elems, false)
+}
+
+// ToXForwardedForVerbatim is like [ToXForwardedFor], but emits for
+// nodes that are not an IP address as is, without port. This keeps
+// the number of entries equal to the number of elements with a
+// for node, but downstream services may fail to parse the entries.
+func ToXForwardedForVerbatim(elems []*Element) string {
+	return toXForwardedFor(elems, true)
+}
+
+func toXForwardedFor(elems []*Element, verbatim bool) string {
+	var b strings.Builder
+	for _, e := range elems {
+		if e == nil || e.For == "" {
+			continue
+		}
+		var entry string
+		if addr, ok := e.For.Addr(); ok {
+			entry = addr.Unmap().String()
+		} else if verbatim {
+			entry, _ = e.For.split()
+		} else {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(entry)
+	}
+	return b.String()
+}
+
+// ToXForwardedProto returns the last non-empty proto of the
+// elements as X-Forwarded-Proto value.
+func ToXForwardedProto(elems []*Element) string {
+	for _, e := range slices.Backward(elems) {
+		if e != nil && e.Proto != "" {
+			return e.Proto
+		}
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package forwarded
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFromXForwardedFor(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []*Element
+	}{
+		{"", nil},
+		{"192.0.2.43", []*Element{{For: "192.0.2.43"}}},
+		{
+			"192.0.2.43, 2001:db8:cafe::17,,198.51.100.17:4711",
+			[]*Element{{For: "192.0.2.43"}, {For: "[2001:db8:cafe::17]"}, {For: "198.51.100.17:4711"}},
+		},
+		{
+			"[2001:db8::17]:4711, ::ffff:192.0.2.43, unknown",
+			[]*Element{{For: "[2001:db8::17]:4711"}, {For: "192.0.2.43"}, {For: "unknown"}},
+		},
+	}
+
+	for _, c := range cases {
+		if got := FromXForwardedFor(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("FromXForwardedFor(%q) = %v, want: %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestFromXForwardedHeaders(t *testing.T) {
+	cases := []struct {
+		name   string
+		header http.Header
+		want   []*Element
+	}{
+		{"empty", http.Header{}, nil},
+		{
+			name: "single",
+			header: http.Header{
+				"X-Forwarded-For":   {"192.0.2.43, 10.0.0.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"example.com"},
+			},
+			want: []*Element{
+				{For: "192.0.2.43", Proto: "https", Host: "example.com"},
+				{For: "10.0.0.1"},
+			},
+		},
+		{
+			name: "multiple",
+			header: http.Header{
+				"X-Forwarded-For":   {"192.0.2.43", "10.0.0.1"},
+				"X-Forwarded-Proto": {"https, http"},
+			},
+			want: []*Element{
+				{For: "192.0.2.43", Proto: "https"},
+				{For: "10.0.0.1", Proto: "http"},
+			},
+		},
+		{
+			name: "no-for",
+			header: http.Header{
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"example.com"},
+			},
+			want: []*Element{{Proto: "https", Host: "example.com"}},
+		},
+	}
+
+	for _, c := range cases {
+		r := &http.Request{Header: c.header}
+		if got := FromXForwardedHeaders(r); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: FromXForwardedHeaders() = %v, want: %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestToXForwardedFor(t *testing.T) {
+	elems := []*Element{
+		{For: "192.0.2.43:4711", Proto: "https"},
+		{For: "[2001:db8:cafe::17]"},
+		{For: "_hidden:_port"},
+		{By: "10.0.0.1"},
+		nil,
+		{For: "unknown", Proto: "http"},
+		{For: `[::ffff:198.51.100.17]:80`},
+	}
+
+	if got, want := ToXForwardedFor(elems), "192.0.2.43, 2001:db8:cafe::17, 198.51.100.17"; got != want {
+		t.Errorf("ToXForwardedFor() = %q, want: %q", got, want)
+	}
+	if got, want := ToXForwardedForVerbatim(elems), "192.0.2.43, 2001:db8:cafe::17, _hidden, unknown, 198.51.100.17"; got != want {
+		t.Errorf("ToXForwardedForVerbatim() = %q, want: %q", got, want)
+	}
+	if got := ToXForwardedFor(nil); got != "" {
+		t.Errorf("ToXForwardedFor(nil) = %q, want empty", got)
+	}
+
+	if got, want := ToXForwardedProto(elems), "http"; got != want {
+		t.Errorf("ToXForwardedProto() = %q, want: %q", got, want)
+	}
+	if got := ToXForwardedProto(elems[1:5]); got != "" {
+		t.Errorf("ToXForwardedProto(no proto) = %q, want empty", got)
+	}
+}